@@ -0,0 +1,182 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// AgeKeys are the recipients to encrypt to and the identities to decrypt with
+type AgeKeys struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+}
+
+// NewAgeKeys loads the age.recipients and age.identities configured in syncrets.yml
+func NewAgeKeys(v *viper.Viper) (*AgeKeys, error) {
+	keys := &AgeKeys{}
+	for _, recipient := range v.GetStringSlice("age.recipients") {
+		r, err := age.ParseRecipients(strings.NewReader(recipient))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient '%s': %v", recipient, err)
+		}
+		keys.Recipients = append(keys.Recipients, r...)
+	}
+	for _, file := range v.GetStringSlice("age.identities") {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity file '%s': %v", file, err)
+		}
+		keys.Identities = append(keys.Identities, ids...)
+	}
+	log.Printf("Loaded %d age recipients and %d identities\n", len(keys.Recipients), len(keys.Identities))
+	return keys, nil
+}
+
+// encrypt b to the recipients, armored if requested
+func (k *AgeKeys) encrypt(out io.Writer, b []byte, armored bool) error {
+	if len(k.Recipients) == 0 {
+		return errors.New("no age recipients configured")
+	}
+	var armorWriter io.WriteCloser
+	if armored {
+		armorWriter = armor.NewWriter(out)
+		out = armorWriter
+	}
+	w, err := age.Encrypt(out, k.Recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if armorWriter != nil {
+		return armorWriter.Close()
+	}
+	return nil
+}
+
+// decrypt an age file, armored or not, with the identities
+func (k *AgeKeys) decrypt(in io.Reader) ([]byte, error) {
+	if len(k.Identities) == 0 {
+		return nil, errors.New("no age identities configured")
+	}
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	in = bytes.NewReader(b)
+	if bytes.HasPrefix(b, []byte(armor.Header)) {
+		in = armor.NewReader(in)
+	}
+	r, err := age.Decrypt(in, k.Identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// AgeEndpoint reads and writes the nested JSON structure as an age encrypted file
+type AgeEndpoint struct {
+	kv     map[string]interface{}
+	keys   *AgeKeys
+	fields nestedFields
+}
+
+// NewAgeEndpoint ...
+func NewAgeEndpoint(keys *AgeKeys) *AgeEndpoint {
+	return &AgeEndpoint{kv: make(map[string]interface{}), keys: keys}
+}
+
+// Visit ...
+func (a *AgeEndpoint) Visit(s core.Secret) {
+	AddSecretToKV(s, a.kv)
+	a.fields.add(s)
+}
+
+// Walk the secrets read by Unmarshal
+func (a *AgeEndpoint) Walk(visitor core.Visitor) {
+	WalkKV(a.kv, visitor)
+}
+
+// Marshal encrypts the secrets to all the recipients
+func (a *AgeEndpoint) Marshal(out io.Writer) error {
+	if err := a.fields.err(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(a.kv)
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	if err := a.keys.encrypt(out, b, false); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// Unmarshal decrypts the secrets with the identities
+func (a *AgeEndpoint) Unmarshal(in io.Reader) error {
+	b, err := a.keys.decrypt(in)
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	kv := make(map[string]interface{})
+	if err := json.Unmarshal(b, &kv); err != nil {
+		return err
+	}
+	a.kv = kv
+	return nil
+}
+
+// ageCipher stores each secret of a git repository as an armored age file
+type ageCipher struct {
+	keys *AgeKeys
+}
+
+func (c *ageCipher) Ext() string {
+	return ".age"
+}
+
+func (c *ageCipher) Encrypt(data map[string]interface{}) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	if err := c.keys.encrypt(out, b, true); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (c *ageCipher) Decrypt(b []byte) (map[string]interface{}, error) {
+	plain, err := c.keys.decrypt(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]interface{}
+	err = json.Unmarshal(plain, &data)
+	return data, err
+}
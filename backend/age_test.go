@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// setupAgeViper configures recipients for n new identities, only the first
+// of which is configured as an identity
+func setupAgeViper(t *testing.T, n int) (*viper.Viper, string) {
+	var recipients []string
+	var identity string
+	for i := 0; i < n; i++ {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipients = append(recipients, id.Recipient().String())
+		if identity == "" {
+			identity = id.String()
+		}
+	}
+	f, err := ioutil.TempFile("", "syncrets-age-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("# test identity\n" + identity + "\n")
+	f.Close()
+	v := viper.New()
+	v.Set("age.recipients", recipients)
+	v.Set("age.identities", []string{f.Name()})
+	return v, f.Name()
+}
+
+func TestAge_RoundTrip(t *testing.T) {
+	v, identityFile := setupAgeViper(t, 2)
+	defer os.Remove(identityFile)
+	keys, err := NewAgeKeys(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Recipients) != 2 || len(keys.Identities) != 1 {
+		t.Fatalf("Expected 2 recipients and 1 identity: %+v\n", keys)
+	}
+	secrets := []core.Secret{
+		{Path: "/secret/foo", Value: "bar"},
+		{Path: "/secret/foo/bar", Value: "foobar"},
+	}
+	out := NewAgeEndpoint(keys)
+	for _, s := range secrets {
+		out.Visit(s)
+	}
+	buf := new(bytes.Buffer)
+	if err := out.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("foobar")) {
+		t.Fatal("Expected the secrets to be encrypted")
+	}
+	in := NewAgeEndpoint(keys)
+	if err := in.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	in.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+}
+
+func TestAge_MissingKeys(t *testing.T) {
+	keys, err := NewAgeKeys(viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewAgeEndpoint(keys).Marshal(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected an error without recipients")
+	}
+	if err := NewAgeEndpoint(keys).Unmarshal(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected an error without identities")
+	}
+	v := viper.New()
+	v.Set("age.recipients", []string{"not-a-recipient"})
+	if _, err := NewAgeKeys(v); err == nil {
+		t.Fatal("Expected an error for an invalid recipient")
+	}
+}
+
+func TestGitRepo_Age(t *testing.T) {
+	_, root, keydir := setupGitRepo(t)
+	defer os.RemoveAll(root)
+	defer os.RemoveAll(keydir)
+	v, identityFile := setupAgeViper(t, 1)
+	defer os.Remove(identityFile)
+	g, err := NewGitBackend(v, []string{GitScheme + root + "?encryption=age"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := core.Secret{Path: "/secret/app/token", Value: "t0ken"}
+	if err := g.Write(secret); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(root + "/secret/app/token.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("-----BEGIN AGE ENCRYPTED FILE-----")) {
+		t.Fatalf("Expected an armored age file but found: %s\n", b)
+	}
+	c := &collector{}
+	g.Walk(c)
+	if !reflect.DeepEqual(c.secrets, []core.Secret{secret}) {
+		t.Fatalf("Expected: %v but result was: %v\n", secret, c.secrets)
+	}
+}
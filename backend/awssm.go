@@ -0,0 +1,300 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+// AWSSMScheme selects an AWS Secrets Manager endpoint, e.g. awssm://us-east-1/app/
+const AWSSMScheme = "awssm://"
+
+// AWSSecretsManager implements the syncrets AWS Secrets Manager backend
+type AWSSecretsManager struct {
+	region         string
+	url            *url.URL
+	origURL        *url.URL
+	path           string
+	recoveryWindow int
+	forceDelete    bool
+	creds          awsCredentials
+	client         *http.Client
+}
+
+// awsError is the error document returned by the AWS JSON protocol
+type awsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// isNotFound reports whether err is an AWS ResourceNotFoundException
+func isNotFound(err error) bool {
+	e, ok := err.(*awsError)
+	return ok && strings.HasSuffix(e.Type, "ResourceNotFoundException")
+}
+
+// GetName ...
+func (sm *AWSSecretsManager) GetName() string {
+	return sm.region
+}
+
+// GetPath ...
+func (sm *AWSSecretsManager) GetPath() string {
+	return sm.path
+}
+
+// GetURL ...
+func (sm *AWSSecretsManager) GetURL() *url.URL {
+	return sm.url
+}
+
+// GetRawURL ...
+func (sm *AWSSecretsManager) GetRawURL() *url.URL {
+	return sm.origURL
+}
+
+// call an action of the Secrets Manager JSON API
+func (sm *AWSSecretsManager) call(action string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", sm.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager."+action)
+	signV4(req, body, sm.creds, sm.region, "secretsmanager", time.Now())
+	resp, err := sm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &awsError{}
+		if json.Unmarshal(respBody, e) != nil || e.Type == "" {
+			return fmt.Errorf("secretsmanager %s: %s", action, resp.Status)
+		}
+		// the type may be qualified, e.g. com.amazonaws...#ResourceNotFoundException
+		e.Type = e.Type[strings.LastIndex(e.Type, "#")+1:]
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// secretName maps a secret path to a secret name
+func secretName(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+// secretString returns a single value as is and several fields as a JSON object
+func secretString(secret core.Secret) (string, error) {
+	if len(secret.Fields) == 0 {
+		return secret.Value, nil
+	}
+	b, err := json.Marshal(secret.Data())
+	return string(b), err
+}
+
+// parseSecretString returns the fields of a JSON object or else a single value
+func parseSecretString(path string, s string) core.Secret {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &data); err == nil {
+			return core.NewSecret(path, data)
+		}
+	}
+	return core.Secret{Path: path, Value: s}
+}
+
+// names lists the names of the secrets under the prefix, following NextToken
+func (sm *AWSSecretsManager) names() ([]string, error) {
+	type filter struct {
+		Key    string
+		Values []string
+	}
+	type listInput struct {
+		Filters    []filter `json:",omitempty"`
+		MaxResults int
+		NextToken  string `json:",omitempty"`
+	}
+	var listOutput struct {
+		SecretList []struct {
+			Name string
+		}
+		NextToken string
+	}
+	prefix := secretName(sm.path)
+	in := listInput{MaxResults: 100}
+	if prefix != "" {
+		in.Filters = []filter{{Key: "name", Values: []string{prefix}}}
+	}
+	var names []string
+	for {
+		listOutput.NextToken = ""
+		listOutput.SecretList = nil
+		if err := sm.call("ListSecrets", in, &listOutput); err != nil {
+			return nil, err
+		}
+		for _, s := range listOutput.SecretList {
+			// the name filter is not case sensitive and matches any name
+			// starting with the prefix, so check the folder of the name here
+			if UnderPrefix(s.Name, prefix) {
+				names = append(names, s.Name)
+			}
+		}
+		if listOutput.NextToken == "" {
+			break
+		}
+		in.NextToken = listOutput.NextToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (sm *AWSSecretsManager) read(name string) (*core.Secret, error) {
+	var out struct {
+		SecretString string
+		SecretBinary string
+	}
+	if err := sm.call("GetSecretValue", map[string]string{"SecretId": name}, &out); err != nil {
+		return nil, err
+	}
+	value := out.SecretString
+	if value == "" && out.SecretBinary != "" {
+		b, err := base64.StdEncoding.DecodeString(out.SecretBinary)
+		if err != nil {
+			return nil, err
+		}
+		value = string(b)
+	}
+	secret := parseSecretString("/"+name, value)
+	return &secret, nil
+}
+
+// Read the secret at path, nil if there is none
+func (sm *AWSSecretsManager) Read(path string) (*core.Secret, error) {
+	secret, err := sm.read(secretName(path))
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return secret, err
+}
+
+// Walk the secrets...
+func (sm *AWSSecretsManager) Walk(visitor core.Visitor) {
+	names, err := sm.names()
+	if err != nil {
+		log.Printf("   -> list error: %v\n", err)
+		core.ReportError(visitor, sm.path, err)
+		return
+	}
+	log.Printf("-> walk names: %v\n", names)
+	for _, name := range names {
+		secret, err := sm.read(name)
+		if err != nil {
+			log.Printf("       !! err: %v\n", err)
+			core.ReportError(visitor, name, err)
+			continue
+		}
+		visitor.Visit(*secret)
+		log.Printf("       <- visited path=%s\n", secret.Path)
+	}
+}
+
+// Write puts a new value, creating the secret if it does not exist yet
+func (sm *AWSSecretsManager) Write(secret core.Secret) error {
+	value, err := secretString(secret)
+	if err != nil {
+		return err
+	}
+	name := secretName(secret.Path)
+	err = sm.call("PutSecretValue", map[string]string{"SecretId": name, "SecretString": value}, nil)
+	if isNotFound(err) {
+		log.Printf("Creating secret %s\n", name)
+		err = sm.call("CreateSecret", map[string]string{"Name": name, "SecretString": value}, nil)
+	}
+	return err
+}
+
+// Delete schedules the deletion of a secret after its recovery window
+func (sm *AWSSecretsManager) Delete(secret core.Secret) error {
+	in := map[string]interface{}{"SecretId": secretName(secret.Path)}
+	if sm.forceDelete {
+		in["ForceDeleteWithoutRecovery"] = true
+	} else if sm.recoveryWindow > 0 {
+		in["RecoveryWindowInDays"] = sm.recoveryWindow
+	}
+	return sm.call("DeleteSecret", in, nil)
+}
+
+// NewAWSSecretsManagerBackend returns a backend for an awssm://region/prefix URL.
+// The endpoint query parameter (or AWS_ENDPOINT_URL) overrides the AWS endpoint,
+// recovery_window and force_delete control how secrets are deleted.
+func NewAWSSecretsManagerBackend(args []string) (*AWSSecretsManager, error) {
+	if len(args) < 1 {
+		return nil, errors.New("source argument is missing")
+	}
+	u := core.ParseURL(args[0])
+	if u == nil {
+		return nil, errors.New("cannot parse url")
+	}
+	sm := &AWSSecretsManager{region: u.Host, origURL: u, path: u.Path, client: http.DefaultClient}
+	if sm.region == "" {
+		return nil, fmt.Errorf("missing region in %s", args[0])
+	}
+	query := u.Query()
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", sm.region)
+	}
+	if sm.url = core.ParseURL(endpoint); sm.url == nil {
+		return nil, fmt.Errorf("cannot parse endpoint url: %s", endpoint)
+	}
+	if window := query.Get("recovery_window"); window != "" {
+		days, err := strconv.Atoi(window)
+		if err != nil || days < 7 || days > 30 {
+			return nil, fmt.Errorf("recovery_window must be between 7 and 30 days: %s", window)
+		}
+		sm.recoveryWindow = days
+	}
+	sm.forceDelete = query.Get("force_delete") == "true"
+	sm.creds = awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if sm.creds.AccessKeyID == "" || sm.creds.SecretAccessKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	log.Printf("%s using url: %v\n", sm.region, sm.url)
+	return sm, nil
+}
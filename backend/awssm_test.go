@@ -0,0 +1,176 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+// secretsManagerStandIn is a minimal stand-in for the Secrets Manager JSON API
+type secretsManagerStandIn struct {
+	pageSize int
+	secrets  map[string]string
+	deleted  map[string]map[string]interface{}
+}
+
+func (sm *secretsManagerStandIn) fail(w http.ResponseWriter, errType string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"__type": errType, "message": "stand-in error"})
+}
+
+func (sm *secretsManagerStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		sm.fail(w, "UnrecognizedClientException")
+		return
+	}
+	var in map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&in)
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "secretsmanager.") {
+	case "ListSecrets":
+		var names []string
+		for name := range sm.secrets {
+			filters, _ := in["Filters"].([]interface{})
+			if len(filters) == 0 || strings.HasPrefix(name, filters[0].(map[string]interface{})["Values"].([]interface{})[0].(string)) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		start := 0
+		if token, ok := in["NextToken"].(string); ok {
+			start = sort.SearchStrings(names, token)
+		}
+		out := map[string]interface{}{}
+		var list []map[string]string
+		for i := start; i < len(names); i++ {
+			if len(list) == sm.pageSize {
+				out["NextToken"] = names[i]
+				break
+			}
+			list = append(list, map[string]string{"Name": names[i]})
+		}
+		out["SecretList"] = list
+		json.NewEncoder(w).Encode(out)
+	case "GetSecretValue":
+		value, ok := sm.secrets[in["SecretId"].(string)]
+		if !ok {
+			sm.fail(w, "ResourceNotFoundException")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": value})
+	case "PutSecretValue":
+		if _, ok := sm.secrets[in["SecretId"].(string)]; !ok {
+			sm.fail(w, "com.amazonaws.secretsmanager#ResourceNotFoundException")
+			return
+		}
+		sm.secrets[in["SecretId"].(string)] = in["SecretString"].(string)
+		w.Write([]byte("{}"))
+	case "CreateSecret":
+		sm.secrets[in["Name"].(string)] = in["SecretString"].(string)
+		w.Write([]byte("{}"))
+	case "DeleteSecret":
+		delete(sm.secrets, in["SecretId"].(string))
+		sm.deleted[in["SecretId"].(string)] = in
+		w.Write([]byte("{}"))
+	}
+}
+
+func setupAWSSecretsManager(t *testing.T, standIn *secretsManagerStandIn, query string) (*AWSSecretsManager, *httptest.Server) {
+	server := httptest.NewServer(standIn)
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	sm, err := NewAWSSecretsManagerBackend([]string{"awssm://us-east-1/app/?endpoint=" + server.URL + query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm, server
+}
+
+func TestAWSSecretsManager_Walk(t *testing.T) {
+	standIn := &secretsManagerStandIn{pageSize: 2, secrets: map[string]string{
+		"app/api-key":     "s3cret",
+		"app/db":          `{"user":"admin","password":"hunter2"}`,
+		"app/token":       "t0ken",
+		"other/ignored":   "x",
+		"app/json-string": `{not json`,
+	}}
+	sm, server := setupAWSSecretsManager(t, standIn, "")
+	defer server.Close()
+	c := &collector{}
+	sm.Walk(c)
+	expected := []core.Secret{
+		{Path: "/app/api-key", Value: "s3cret"},
+		{Path: "/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+		{Path: "/app/json-string", Value: "{not json"},
+		{Path: "/app/token", Value: "t0ken"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestAWSSecretsManager_WalkPrefix(t *testing.T) {
+	standIn := &secretsManagerStandIn{pageSize: 10, secrets: map[string]string{
+		"app":               "leaf",
+		"app/db":            "hunter2",
+		"application/token": "other",
+	}}
+	sm, server := setupAWSSecretsManager(t, standIn, "")
+	defer server.Close()
+	sm.path = "/app"
+	c := &collector{}
+	sm.Walk(c)
+	expected := []core.Secret{
+		{Path: "/app", Value: "leaf"},
+		{Path: "/app/db", Value: "hunter2"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestAWSSecretsManager_WriteDelete(t *testing.T) {
+	standIn := &secretsManagerStandIn{
+		secrets: map[string]string{"app/existing": "old"},
+		deleted: map[string]map[string]interface{}{},
+	}
+	sm, server := setupAWSSecretsManager(t, standIn, "&recovery_window=7")
+	defer server.Close()
+	if err := sm.Write(core.Secret{Path: "/app/existing", Value: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Write(core.Secret{Path: "/app/db", Fields: map[string]string{"password": "hunter2"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"app/existing": "new", "app/db": `{"password":"hunter2"}`}
+	if !reflect.DeepEqual(standIn.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, standIn.secrets)
+	}
+	if err := sm.Delete(core.Secret{Path: "/app/db"}); err != nil {
+		t.Fatal(err)
+	}
+	if days := standIn.deleted["app/db"]["RecoveryWindowInDays"]; days != 7.0 {
+		t.Fatalf("Expected a 7 day recovery window but was: %v\n", days)
+	}
+}
+
+func TestAWSSecretsManager_Options(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	if _, err := NewAWSSecretsManagerBackend([]string{"awssm://us-east-1/app/?recovery_window=3"}); err == nil {
+		t.Fatal("Expected an error for a recovery window under 7 days")
+	}
+	sm, err := NewAWSSecretsManagerBackend([]string{"awssm://eu-west-1/app/?force_delete=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sm.forceDelete || sm.GetURL().String() != "https://secretsmanager.eu-west-1.amazonaws.com" {
+		t.Fatalf("Unexpected settings: %+v\n", sm)
+	}
+}
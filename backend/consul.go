@@ -0,0 +1,305 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// ConsulScheme selects a Consul KV endpoint, e.g. consul://consul-a/app/config/
+const ConsulScheme = "consul://"
+
+// Consul implements the syncrets consul KV backend
+type Consul struct {
+	name    string
+	url     *url.URL
+	origURL *url.URL
+	path    string
+	token   string
+	viper   *viper.Viper
+	client  *http.Client
+	isValid *bool
+}
+
+// GetName ...
+func (c *Consul) GetName() string {
+	return c.name
+}
+
+// GetSection returns the section of the config holding the consul aliases
+func (c *Consul) GetSection() string {
+	return "consul"
+}
+
+// GetPath ...
+func (c *Consul) GetPath() string {
+	return c.path
+}
+
+// GetURL ...
+func (c *Consul) GetURL() *url.URL {
+	return c.url
+}
+
+// GetRawURL ...
+func (c *Consul) GetRawURL() *url.URL {
+	return c.origURL
+}
+
+// do sends a request for a KV key to the consul HTTP API
+func (c *Consul) do(method string, key string, query string, body io.Reader) (*http.Response, error) {
+	u := *c.url
+	u.Path = "/v1/kv/" + strings.TrimPrefix(key, "/")
+	u.RawQuery = query
+	return c.request(method, u.String(), body)
+}
+
+func (c *Consul) request(method string, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	return c.client.Do(req)
+}
+
+func consulError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("consul %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// keys lists the keys under a prefix, recursively
+func (c *Consul) keys(prefix string) ([]string, error) {
+	resp, err := c.do("GET", prefix, "keys", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, consulError(resp)
+	}
+	var keys []string
+	err = json.NewDecoder(resp.Body).Decode(&keys)
+	return keys, err
+}
+
+// read the raw value of a key
+func (c *Consul) read(key string) (*core.Secret, error) {
+	resp, err := c.do("GET", key, "raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, consulError(resp)
+	}
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &core.Secret{Path: "/" + strings.TrimPrefix(key, "/"), Value: string(value)}, nil
+}
+
+// Read the secret at path, nil if there is none
+func (c *Consul) Read(path string) (*core.Secret, error) {
+	return c.read(path)
+}
+
+// Write ...
+func (c *Consul) Write(secret core.Secret) error {
+	if err := singleValue(secret, "consul://"); err != nil {
+		return err
+	}
+	resp, err := c.do("PUT", secret.Path, "", strings.NewReader(secret.Value))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return consulError(resp)
+	}
+	return nil
+}
+
+// Delete ...
+func (c *Consul) Delete(secret core.Secret) error {
+	resp, err := c.do("DELETE", secret.Path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return consulError(resp)
+	}
+	return nil
+}
+
+// inPrefix reports whether a listed key is the walked path itself or below it,
+// consul lists keys by raw string prefix so app also matches application/
+func (c *Consul) inPrefix(key string) bool {
+	if c.path == "" || strings.HasSuffix(c.path, "/") {
+		return true
+	}
+	return key == c.path || strings.HasPrefix(key, c.path+"/")
+}
+
+// Walk the secrets...
+func (c *Consul) Walk(visitor core.Visitor) {
+	keys, err := c.keys(c.path)
+	if err != nil {
+		log.Printf("   -> list error: %v\n", err)
+		core.ReportError(visitor, c.path, err)
+		return
+	}
+	log.Printf("-> walk keys: %v\n", keys)
+	for _, key := range keys {
+		// keys ending with / are folders without a value
+		if strings.HasSuffix(key, "/") || !c.inPrefix(key) {
+			continue
+		}
+		secret, err := c.read(key)
+		if err != nil {
+			log.Printf("       !! err: %v\n", err)
+			core.ReportError(visitor, key, err)
+			continue
+		}
+		if secret != nil {
+			visitor.Visit(*secret)
+			log.Printf("       <- visited path=%s\n", secret.Path)
+		}
+	}
+}
+
+// Authenticate with the consul agent, using the same token file scheme as vault
+func (c *Consul) Authenticate() error {
+	c.Load()
+	if c.IsValid() {
+		return nil
+	}
+	c.isValid = nil
+	if token, hasEnv := os.LookupEnv("CONSUL_HTTP_TOKEN"); hasEnv && token != c.token {
+		log.Printf("Using CONSUL_HTTP_TOKEN environment variable\n")
+		c.token = token
+		if c.IsValid() {
+			return nil
+		}
+		c.isValid = nil
+	}
+	token, err := promptSecret("token: ")
+	if err != nil {
+		return err
+	}
+	c.token = token
+	return nil
+}
+
+// IsValid checks the ACL token, consul agents without ACLs accept any token
+func (c *Consul) IsValid() bool {
+	if c.isValid != nil {
+		return *c.isValid
+	}
+	valid := false
+	u := *c.url
+	u.Path = "/v1/acl/token/self"
+	resp, err := c.request("GET", u.String(), nil)
+	if err != nil {
+		log.Printf("token self lookup failed: %v\n", err)
+	} else {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		valid = resp.StatusCode == http.StatusOK || strings.Contains(string(msg), "ACL support disabled")
+		log.Printf("token self lookup returned %t: %s\n", valid, resp.Status)
+	}
+	c.isValid = &valid
+	return *c.isValid
+}
+
+// Load the token from consul.<alias>.token.file if one is configured
+func (c *Consul) Load() (string, error) {
+	vkey := fmt.Sprintf("consul.%s.token.file", c.name)
+	tokenFile := c.viper.GetString(vkey)
+	if tokenFile == "" {
+		return "", fmt.Errorf("No token defined for %v", vkey)
+	}
+	tokenBytes, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		log.Printf("Error reading file %v: %v\n", tokenFile, err)
+		return "", err
+	}
+	log.Printf("%v is configured: %v\n", vkey, tokenFile)
+	c.token = strings.TrimSpace(string(tokenBytes))
+	return c.token, nil
+}
+
+// Store the token in consul.<alias>.token.file if one is configured
+func (c *Consul) Store() {
+	vkey := fmt.Sprintf("consul.%s.token.file", c.name)
+	tokenFile := c.viper.GetString(vkey)
+	if tokenFile == "" {
+		log.Printf("Not storing token. No token file configured for %s\n", vkey)
+		return
+	}
+	err := ioutil.WriteFile(tokenFile, []byte(c.token), 0600)
+	if err != nil {
+		log.Printf("Failed to write token file '%s': %v\n", tokenFile, err)
+		return
+	}
+	log.Printf("Stored updated token in %s\n", tokenFile)
+}
+
+func (c *Consul) resolveArgs(args []string) error {
+	if len(args) < 1 {
+		return errors.New("source argument is missing")
+	}
+	c.origURL = core.ParseURL(args[0])
+	if c.origURL == nil {
+		return errors.New("cannot parse url")
+	}
+	c.path = strings.TrimPrefix(c.origURL.Path, "/")
+	alias := core.ReverseLookupSectionAlias(c.viper, "consul", c.origURL)
+	if alias == "" {
+		alias = c.origURL.Hostname()
+	}
+	if u := core.ResolveSectionAlias(c.viper, "consul", alias); u != nil {
+		c.url = u
+	} else {
+		c.url = &url.URL{Scheme: "http", Host: c.origURL.Host}
+	}
+	c.name = alias
+	log.Printf("%s using url: %v\n", c.name, c.url)
+	return nil
+}
+
+// NewConsulBackend returns a consul KV backend based on the supplied arguments
+func NewConsulBackend(viper *viper.Viper, args []string) (*Consul, error) {
+	c := &Consul{viper: viper, client: http.DefaultClient}
+	if err := c.resolveArgs(args); err != nil {
+		return nil, err
+	}
+	if err := c.Authenticate(); err != nil {
+		return nil, err
+	}
+	if !c.IsValid() {
+		return nil, errors.New("consul authentication failed")
+	}
+	c.Store()
+	return c, nil
+}
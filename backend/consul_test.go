@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+// consulStandIn is a minimal stand-in for the consul KV HTTP API
+type consulStandIn struct {
+	token string
+	kv    map[string]string
+}
+
+func (c *consulStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != c.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == "/v1/acl/token/self" {
+		w.Write([]byte(`{"SecretID":"` + c.token + `"}`))
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	switch r.Method {
+	case "GET":
+		if _, ok := r.URL.Query()["keys"]; ok {
+			var keys []string
+			for k := range c.kv {
+				if strings.HasPrefix(k, key) {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			sort.Strings(keys)
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+		value, ok := c.kv[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(value))
+	case "PUT":
+		value, _ := ioutil.ReadAll(r.Body)
+		c.kv[key] = string(value)
+		w.Write([]byte("true"))
+	case "DELETE":
+		delete(c.kv, key)
+		w.Write([]byte("true"))
+	}
+}
+
+func setupConsul(t *testing.T, standIn *consulStandIn) (*Consul, *httptest.Server, string) {
+	server := httptest.NewServer(standIn)
+	tokenFile, err := ioutil.TempFile("", "syncrets-consul-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile.WriteString(standIn.token + "\n")
+	tokenFile.Close()
+	testViper := getViper("./testdata/syncrets-test1.yml")
+	testViper.Set("consul.consul-a.url", server.URL)
+	testViper.Set("consul.consul-a.token.file", tokenFile.Name())
+	c, err := NewConsulBackend(testViper, []string{"consul://consul-a/app/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, server, tokenFile.Name()
+}
+
+func TestConsul_Walk(t *testing.T) {
+	standIn := &consulStandIn{token: "acl-token", kv: map[string]string{
+		"app/":            "",
+		"app/db/password": "hunter2",
+		"app/db/user":     "admin",
+		"other/key":       "ignored",
+	}}
+	c, server, tokenFile := setupConsul(t, standIn)
+	defer server.Close()
+	defer os.Remove(tokenFile)
+	collected := &collector{}
+	c.Walk(collected)
+	expected := []core.Secret{
+		{Path: "/app/db/password", Value: "hunter2"},
+		{Path: "/app/db/user", Value: "admin"},
+	}
+	if !reflect.DeepEqual(collected.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, collected.secrets)
+	}
+}
+
+func TestConsul_WriteDelete(t *testing.T) {
+	standIn := &consulStandIn{token: "acl-token", kv: map[string]string{}}
+	c, server, tokenFile := setupConsul(t, standIn)
+	defer server.Close()
+	defer os.Remove(tokenFile)
+	if err := c.Write(core.Secret{Path: "/app/api/key", Value: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	if standIn.kv["app/api/key"] != "s3cret" {
+		t.Fatalf("Expected value to be written but kv was: %v\n", standIn.kv)
+	}
+	if err := c.Delete(core.Secret{Path: "/app/api/key"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := standIn.kv["app/api/key"]; ok {
+		t.Fatalf("Expected value to be deleted but kv was: %v\n", standIn.kv)
+	}
+	if err := c.Write(core.Secret{Path: "/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}}); err == nil {
+		t.Fatal("Expected a secret with several fields to be refused")
+	}
+	if _, ok := standIn.kv["app/db"]; ok {
+		t.Fatalf("Expected nothing to be written but kv was: %v\n", standIn.kv)
+	}
+}
+
+func TestConsul_InvalidToken(t *testing.T) {
+	standIn := &consulStandIn{token: "acl-token", kv: map[string]string{}}
+	c, server, tokenFile := setupConsul(t, standIn)
+	defer server.Close()
+	defer os.Remove(tokenFile)
+	c.token = "wrong-token"
+	c.isValid = nil
+	if c.IsValid() {
+		t.Fatal("Expected the wrong token to be invalid")
+	}
+	if err := c.Write(core.Secret{Path: "/app/key", Value: "x"}); err == nil {
+		t.Fatal("Expected an error writing with the wrong token")
+	}
+}
+
+func TestConsul_WalkPrefix(t *testing.T) {
+	standIn := &consulStandIn{token: "acl-token", kv: map[string]string{
+		"app":               "leaf",
+		"app/db/password":   "hunter2",
+		"application/token": "other",
+	}}
+	c, server, tokenFile := setupConsul(t, standIn)
+	defer server.Close()
+	defer os.Remove(tokenFile)
+	c.path = "app"
+	collected := &collector{}
+	c.Walk(collected)
+	expected := []core.Secret{
+		{Path: "/app", Value: "leaf"},
+		{Path: "/app/db/password", Value: "hunter2"},
+	}
+	if !reflect.DeepEqual(collected.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, collected.secrets)
+	}
+}
@@ -0,0 +1,263 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+// DirScheme selects a directory tree endpoint, e.g. dir:///run/secrets
+const DirScheme = "dir://"
+
+// dirLeafFile holds the value of a secret whose path is also a prefix
+const dirLeafFile = ".value"
+
+// Dir implements an endpoint storing one file per secret under a root directory
+type Dir struct {
+	name    string
+	origURL *url.URL
+	root    string
+	path    string
+	// AllowInsecure allows writing into world-readable directories
+	AllowInsecure bool
+}
+
+// NewDirBackend returns a directory endpoint for a dir:// URL.
+// The host and path of the URL are the root directory, the optional
+// path query parameter restricts Walk to a prefix.
+func NewDirBackend(args []string) (*Dir, error) {
+	if len(args) < 1 {
+		return nil, errors.New("source argument is missing")
+	}
+	u := core.ParseURL(args[0])
+	if u == nil {
+		return nil, errors.New("cannot parse url")
+	}
+	d := &Dir{name: u.Host, origURL: u, root: u.Host + u.Path}
+	if d.root == "" {
+		return nil, fmt.Errorf("missing root directory in %s", args[0])
+	}
+	d.path = u.Query().Get("path")
+	if d.path == "" {
+		d.path = "/"
+	}
+	log.Printf("%s using root directory: %s\n", args[0], d.root)
+	return d, nil
+}
+
+// GetName ...
+func (d *Dir) GetName() string {
+	return d.name
+}
+
+// GetPath ...
+func (d *Dir) GetPath() string {
+	return d.path
+}
+
+// GetURL ...
+func (d *Dir) GetURL() *url.URL {
+	return d.origURL
+}
+
+// GetRawURL ...
+func (d *Dir) GetRawURL() *url.URL {
+	return d.origURL
+}
+
+// file returns the file for a secret path, refusing paths outside the root
+func (d *Dir) file(path string) (string, error) {
+	return rootedFile(d.root, path)
+}
+
+// rootedFile returns the file for a secret path below root
+func rootedFile(root string, path string) (string, error) {
+	file := filepath.Join(root, filepath.FromSlash(path))
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("secret path '%s' is outside of %s", path, root)
+	}
+	return file, nil
+}
+
+// mkdirs creates the parent directories of file, moving any secret that
+// is in the way into the .value file of the new directory
+func (d *Dir) mkdirs(file string) error {
+	rel, err := filepath.Rel(d.root, filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	dir := d.root
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	for _, step := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, step)
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			err = os.Mkdir(dir, 0700)
+		} else if err == nil && !info.IsDir() {
+			err = d.pushDown(dir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushDown moves the value of a leaf into the directory that replaces it,
+// through a new temporary file so that no other secret is overwritten
+func (d *Dir) pushDown(file string) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := os.Rename(file, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Mkdir(file, 0700); err != nil {
+		os.Rename(tmp, file)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(file, dirLeafFile))
+}
+
+// Read the secret at path, nil if there is none
+func (d *Dir) Read(path string) (*core.Secret, error) {
+	file, err := d.file(path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		file = filepath.Join(file, dirLeafFile)
+	}
+	value, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &core.Secret{Path: "/" + strings.Trim(path, "/"), Value: string(value)}, nil
+}
+
+// singleValue fails the write of a secret with other fields than value to
+// an endpoint storing one value per path, rather than silently dropping them
+func singleValue(secret core.Secret, endpoint string) error {
+	if len(secret.Fields) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(secret.Fields))
+	for name := range secret.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("secret %s has the fields %s, %s stores only a value per path", secret.Path, strings.Join(names, ", "), endpoint)
+}
+
+// Write ...
+func (d *Dir) Write(secret core.Secret) error {
+	if err := singleValue(secret, "dir://"); err != nil {
+		return err
+	}
+	file, err := d.file(secret.Path)
+	if err != nil {
+		return err
+	}
+	if err := d.mkdirs(file); err != nil {
+		return err
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		file = filepath.Join(file, dirLeafFile)
+	}
+	if err := CheckOutputDir(file, d.AllowInsecure); err != nil {
+		return err
+	}
+	return WriteFileAtomic(file, func(out io.Writer) error {
+		_, err := io.WriteString(out, secret.Value)
+		return err
+	})
+}
+
+// Delete ...
+func (d *Dir) Delete(secret core.Secret) error {
+	file, err := d.file(secret.Path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		file = filepath.Join(file, dirLeafFile)
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	// prune directories left empty by the delete
+	for dir := filepath.Dir(file); dir != filepath.Clean(d.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Walk the secrets...
+func (d *Dir) Walk(visitor core.Visitor) {
+	start, err := d.file(d.path)
+	if err != nil {
+		start = d.root
+	}
+	err = filepath.Walk(start, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == start && os.IsNotExist(err) {
+				// nothing stored under the prefix yet
+				return nil
+			}
+			log.Printf("   -> walk error: %v\n", err)
+			core.ReportError(visitor, file, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, file)
+		if err != nil {
+			return err
+		}
+		path := "/" + filepath.ToSlash(rel)
+		if info.Name() == dirLeafFile {
+			path = strings.TrimSuffix(path, "/"+dirLeafFile)
+		}
+		value, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Printf("       !! err: %v\n", err)
+			core.ReportError(visitor, path, err)
+			return nil
+		}
+		visitor.Visit(core.Secret{Path: path, Value: string(value)})
+		log.Printf("       <- visited path=%s\n", path)
+		return nil
+	})
+	if err != nil {
+		log.Printf("   -> walk error: %v\n", err)
+		core.ReportError(visitor, d.path, err)
+	}
+}
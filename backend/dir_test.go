@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+func setupDir(t *testing.T) (*Dir, string) {
+	root, err := ioutil.TempDir("", "syncrets-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDirBackend([]string{DirScheme + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, root
+}
+
+func TestDir_WriteWalk(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	secrets := []core.Secret{
+		{Path: "/secret/foo", Value: "bar"},
+		{Path: "/secret/foo/bar", Value: "foobar"},
+		{Path: "/secret/gilbert", Value: "sullivan"},
+		{Path: "/secret/it/was/the/best/of/times", Value: "it was the worst of times"},
+	}
+	for _, s := range secrets {
+		if err := d.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(root, "secret", "gilbert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected file mode 0600 but was: %v\n", info.Mode().Perm())
+	}
+	info, err = os.Stat(filepath.Join(root, "secret", "it"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected directory mode 0700 but was: %v\n", info.Mode().Perm())
+	}
+	c := &collector{}
+	d.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+}
+
+func TestDir_PushDownKeepsSiblings(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	secrets := []core.Secret{
+		{Path: "/secret/foo", Value: "bar"},
+		{Path: "/secret/foo.tmp", Value: "kept"},
+	}
+	for _, s := range secrets {
+		if err := d.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// foo becomes a directory, its value must not land on foo.tmp
+	if err := d.Write(core.Secret{Path: "/secret/foo/bar", Value: "foobar"}); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	d.Walk(c)
+	expected := []core.Secret{
+		{Path: "/secret/foo", Value: "bar"},
+		{Path: "/secret/foo/bar", Value: "foobar"},
+		{Path: "/secret/foo.tmp", Value: "kept"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestDir_Fields(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	if err := d.Write(core.Secret{Path: "/secret/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}}); err == nil {
+		t.Fatal("Expected a secret with several fields to be refused")
+	}
+	if err := d.Write(core.Secret{Path: "/secret/api", Value: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	d.Walk(c)
+	expected := []core.Secret{{Path: "/secret/api", Value: "t1"}}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestDir_InsecureRoot(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	os.Chmod(root, 0755)
+	if err := d.Write(core.Secret{Path: "/foo", Value: "bar"}); err == nil {
+		t.Fatal("Expected a world-readable directory to be refused")
+	}
+	d.AllowInsecure = true
+	if err := d.Write(core.Secret{Path: "/foo", Value: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ioutil.ReadDir(root); len(entries) != 1 {
+		t.Fatalf("Expected no temporary files to be left: %v\n", entries)
+	}
+}
+
+func TestDir_Read(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	d.Write(core.Secret{Path: "/secret/foo", Value: "bar"})
+	d.Write(core.Secret{Path: "/secret/foo/bar", Value: "foobar"})
+	for path, value := range map[string]string{"/secret/foo": "bar", "secret/foo/bar/": "foobar"} {
+		s, err := d.Read(path)
+		if err != nil || s == nil || s.Value != value {
+			t.Fatalf("Unexpected secret at %s: %v %v\n", path, s, err)
+		}
+	}
+	if s, err := d.Read("/secret/missing"); err != nil || s != nil {
+		t.Fatalf("Expected no secret: %v %v\n", s, err)
+	}
+}
+
+func TestDir_Delete(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	d.Write(core.Secret{Path: "/secret/foo/bar", Value: "foobar"})
+	d.Write(core.Secret{Path: "/secret/foo", Value: "bar"})
+	if err := d.Delete(core.Secret{Path: "/secret/foo/bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(core.Secret{Path: "/secret/foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "secret")); !os.IsNotExist(err) {
+		t.Fatalf("Expected empty directories to be removed: %v\n", err)
+	}
+	c := &collector{}
+	d.Walk(c)
+	if len(c.secrets) != 0 {
+		t.Fatalf("Expected no secrets but found: %v\n", c.secrets)
+	}
+}
+
+func TestDir_OutsideRoot(t *testing.T) {
+	d, root := setupDir(t)
+	defer os.RemoveAll(root)
+	if err := d.Write(core.Secret{Path: "/secret/../../escape", Value: "x"}); err == nil {
+		t.Fatal("Expected an error writing outside of the root directory")
+	}
+}
@@ -3,34 +3,52 @@ package backend
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"regexp"
 
 	"github.com/Shopify/ejson"
 	"github.com/drmdrew/syncrets/core"
 	"github.com/spf13/viper"
 )
 
+var ejsonPublicKey = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
 type EJSONEndpoint struct {
-	kv map[string]interface{}
+	kv        map[string]interface{}
+	PublicKey string
+	fields    nestedFields
 }
 
 // NewEJSONEndpoint ...
-func NewEJSONEndpoint() *EJSONEndpoint {
-	return &EJSONEndpoint{make(map[string]interface{})}
+func NewEJSONEndpoint(publicKey string) *EJSONEndpoint {
+	return &EJSONEndpoint{kv: make(map[string]interface{}), PublicKey: publicKey}
 }
 
 // Visit ...
 func (j *EJSONEndpoint) Visit(s core.Secret) {
 	AddSecretToKV(s, j.kv)
+	j.fields.add(s)
 }
 
 // Marshal ...
 func (j *EJSONEndpoint) Marshal(out io.Writer) error {
 	var jsonBytes []byte
 	var err error
-	ejsonPubkey := viper.GetString("ejson.public_key")
-	j.kv["_public_key"] = ejsonPubkey
+	if err := j.fields.err(); err != nil {
+		return err
+	}
+	if j.PublicKey == "" {
+		err = errors.New("no ejson public key, select one with --ejson-key, a public_key parameter or ejson.public_key")
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	j.kv["_public_key"] = j.PublicKey
 	if jsonBytes, err = json.Marshal(j.kv); err != nil {
 		log.Printf("ERROR: %v\n", err)
 		return err
@@ -43,3 +61,97 @@ func (j *EJSONEndpoint) Marshal(out io.Writer) error {
 	}
 	return nil
 }
+
+// Merge overlays the visited secrets onto an existing ejson file, see
+// MergeKV. Unchanged values stay encrypted as they are when the existing
+// file can be decrypted and uses the same public key.
+func (j *EJSONEndpoint) Merge(in io.Reader, prefix string, prune bool) error {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]interface{})
+	if err := decodeJSON(bytes.NewReader(b), &existing); err != nil {
+		return err
+	}
+	var plain map[string]interface{}
+	out := new(bytes.Buffer)
+	if err := ejson.Decrypt(bytes.NewReader(b), out, ejsonKeydir(), ""); err != nil {
+		log.Printf("Cannot decrypt the existing file, all synced values are re-encrypted: %v\n", err)
+	} else if err := decodeJSON(out, &plain); err != nil {
+		return err
+	}
+	if existing["_public_key"] != j.PublicKey {
+		// values encrypted for another key must be re-encrypted
+		if plain == nil {
+			return errors.New("cannot merge into an ejson file with another public key without its private key")
+		}
+		existing = plain
+	}
+	delete(existing, "_public_key")
+	delete(plain, "_public_key")
+	j.kv = MergeKV(existing, plain, j.kv, prefix, prune)
+	return nil
+}
+
+// ResolveEJSONPublicKey returns the public key for a selector, which is
+// either the name of a key in the ejson.keys map or a public key itself
+func ResolveEJSONPublicKey(v *viper.Viper, selector string) (string, error) {
+	if key := v.GetString("ejson.keys." + selector); key != "" {
+		return key, nil
+	}
+	if ejsonPublicKey.MatchString(selector) {
+		return selector, nil
+	}
+	return "", fmt.Errorf("'%s' is neither a key in ejson.keys nor an ejson public key", selector)
+}
+
+// ReadEJSONPublicKey returns the _public_key of an existing ejson file, or "" if there is none
+func ReadEJSONPublicKey(file string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	var doc struct {
+		PublicKey string `json:"_public_key"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		log.Printf("Cannot read _public_key of %s: %v\n", file, err)
+		return ""
+	}
+	return doc.PublicKey
+}
+
+// SelectEJSONPublicKey picks the public key for an ejson file: an explicit
+// selector first, then the key of the existing file, then ejson.public_key
+func SelectEJSONPublicKey(v *viper.Viper, selector string, file string) (string, error) {
+	if selector != "" {
+		return ResolveEJSONPublicKey(v, selector)
+	}
+	if key := ReadEJSONPublicKey(file); key != "" {
+		log.Printf("Reusing the _public_key of %s\n", file)
+		return key, nil
+	}
+	return v.GetString("ejson.public_key"), nil
+}
+
+// GenerateEJSONKey writes a new keypair into the ejson keydir and returns the public key
+func GenerateEJSONKey() (string, error) {
+	pub, priv, err := ejson.GenerateKeypair()
+	if err != nil {
+		return "", err
+	}
+	keydir := ejsonKeydir()
+	if err := os.MkdirAll(keydir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filepath.Join(keydir, pub), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(priv); err != nil {
+		return "", err
+	}
+	return pub, nil
+}
@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSelectEJSONPublicKey(t *testing.T) {
+	named := strings.Repeat("a", 64)
+	existing := strings.Repeat("b", 64)
+	fallback := strings.Repeat("c", 64)
+	v := viper.New()
+	v.Set("ejson.public_key", fallback)
+	v.Set("ejson.keys", map[string]interface{}{"team": named})
+	f, err := ioutil.TempFile("", "syncrets-ejson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"_public_key": "` + existing + `", "secret": {"foo": "EJ[1:...]"}}`)
+	f.Close()
+
+	cases := []struct {
+		selector string
+		file     string
+		expected string
+	}{
+		{"team", f.Name(), named},
+		{existing, "", existing},
+		{"", f.Name(), existing},
+		{"", "/does/not/exist.ejson", fallback},
+	}
+	for _, c := range cases {
+		key, err := SelectEJSONPublicKey(v, c.selector, c.file)
+		if err != nil || key != c.expected {
+			t.Fatalf("%+v: expected %s but result was: %s (%v)\n", c, c.expected, key, err)
+		}
+	}
+	if _, err := SelectEJSONPublicKey(v, "unknown", ""); err == nil {
+		t.Fatal("Expected an error for an unknown key name")
+	}
+}
+
+func TestGenerateEJSONKey(t *testing.T) {
+	keydir, err := ioutil.TempDir("", "syncrets-ejson-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keydir)
+	os.Setenv("EJSON_KEYDIR", keydir)
+	pub, err := GenerateEJSONKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(keydir, pub)); err != nil || info.Mode().Perm() != 0400 {
+		t.Fatalf("Expected a 0400 private key file: %v %v\n", info, err)
+	}
+}
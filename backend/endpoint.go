@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// NewEndpoint returns the endpoint for the URL in args, based on its scheme
+func NewEndpoint(v *viper.Viper, args []string) (core.Endpoint, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], DirScheme) {
+		d, err := NewDirBackend(args)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	if len(args) > 0 && strings.HasPrefix(args[0], ConsulScheme) {
+		c, err := NewConsulBackend(v, args)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if len(args) > 0 && strings.HasPrefix(args[0], AWSSMScheme) {
+		sm, err := NewAWSSecretsManagerBackend(args)
+		if err != nil {
+			return nil, err
+		}
+		return sm, nil
+	}
+	if len(args) > 0 && strings.HasPrefix(args[0], GitScheme) {
+		g, err := NewGitBackend(v, args)
+		if err != nil {
+			return nil, err
+		}
+		return g, nil
+	}
+	vault, err := NewVaultBackend(v, args)
+	if err != nil {
+		return nil, err
+	}
+	if vault == nil {
+		return nil, errors.New("vault authentication failed")
+	}
+	return vault, nil
+}
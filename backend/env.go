@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// Supported environment output formats
+const (
+	EnvFormatDotenv = "dotenv"
+	EnvFormatShell  = "shell"
+	EnvFormatFish   = "fish"
+)
+
+var (
+	envInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+	envSafeValue    = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+)
+
+// EnvNameMapper converts secret paths into environment variable names
+type EnvNameMapper struct {
+	// Prefix is stripped from the front of each secret path
+	Prefix string
+	// Separator replaces the "/" between the remaining path steps
+	Separator string
+	// Uppercase the resulting variable name
+	Uppercase bool
+}
+
+// NewEnvNameMapper returns a mapper that strips prefix, joins steps with "_" and uppercases
+func NewEnvNameMapper(prefix string) *EnvNameMapper {
+	return &EnvNameMapper{Prefix: prefix, Separator: "_", Uppercase: true}
+}
+
+// Configure applies the env section configured for an alias in a section of
+// the config, e.g. vault.<alias>.env or consul.<alias>.env
+func (m *EnvNameMapper) Configure(v *viper.Viper, section string, alias string) {
+	vkey := fmt.Sprintf("%s.%s.env", section, alias)
+	if v.IsSet(vkey + ".strip_prefix") {
+		m.Prefix = v.GetString(vkey + ".strip_prefix")
+	}
+	if v.IsSet(vkey + ".separator") {
+		m.Separator = v.GetString(vkey + ".separator")
+	}
+	if v.IsSet(vkey + ".uppercase") {
+		m.Uppercase = v.GetBool(vkey + ".uppercase")
+	}
+	log.Printf("Using env name mapping for '%s': %+v\n", vkey, *m)
+}
+
+// Name returns the environment variable name for a secret path
+func (m *EnvNameMapper) Name(path string) string {
+	prefix := strings.Trim(m.Prefix, "/")
+	path = strings.Trim(path, "/")
+	if prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+		path = strings.TrimPrefix(path[len(prefix):], "/")
+	}
+	var steps []string
+	for _, step := range strings.Split(path, "/") {
+		if step != "" {
+			steps = append(steps, envInvalidChars.ReplaceAllString(step, "_"))
+		}
+	}
+	name := strings.Join(steps, m.Separator)
+	if m.Uppercase {
+		name = strings.ToUpper(name)
+	}
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// EnvEndpoint collects secrets as environment variables
+type EnvEndpoint struct {
+	names  *EnvNameMapper
+	format string
+	vars   map[string]string
+	paths  map[string][]string
+}
+
+// NewEnvEndpoint returns an EnvEndpoint writing the given format
+func NewEnvEndpoint(names *EnvNameMapper, format string) *EnvEndpoint {
+	return &EnvEndpoint{
+		names:  names,
+		format: format,
+		vars:   make(map[string]string),
+		paths:  make(map[string][]string),
+	}
+}
+
+// Visit ...
+func (e *EnvEndpoint) Visit(s core.Secret) {
+	for field, value := range s.Data() {
+		// other fields than value are named after the path and the field, e.g. DB_PASSWORD
+		path := s.Path
+		if field != core.ValueField {
+			path += "/" + field
+		}
+		name := e.names.Name(path)
+		e.vars[name] = value.(string)
+		e.paths[name] = append(e.paths[name], path)
+	}
+}
+
+// Vars returns the collected variables or an error if any names collide
+func (e *EnvEndpoint) Vars() (map[string]string, error) {
+	var collisions []string
+	for name, paths := range e.paths {
+		if name == "" {
+			collisions = append(collisions, fmt.Sprintf("%v map to an empty name", paths))
+		} else if len(paths) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%v all map to %s", paths, name))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("environment variable name collision: %s", strings.Join(collisions, "; "))
+	}
+	return e.vars, nil
+}
+
+// Marshal writes one line per variable, sorted by name
+func (e *EnvEndpoint) Marshal(out io.Writer) error {
+	vars, err := e.Vars()
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		line, err := FormatEnv(e.format, name, vars[name])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, line)
+	}
+	return nil
+}
+
+// FormatEnv formats one variable assignment, quoting the value as the format requires
+func FormatEnv(format string, name string, value string) (string, error) {
+	switch format {
+	case EnvFormatDotenv, "":
+		return name + "=" + dotenvQuote(value), nil
+	case EnvFormatShell:
+		return "export " + name + "=" + shellQuote(value), nil
+	case EnvFormatFish:
+		return "set -gx " + name + " " + fishQuote(value), nil
+	}
+	return "", fmt.Errorf("unknown env format: %s", format)
+}
+
+func dotenvQuote(value string) string {
+	if envSafeValue.MatchString(value) {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(value) + `"`
+}
+
+func shellQuote(value string) string {
+	if value != "" && envSafeValue.MatchString(value) {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func fishQuote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	return "'" + r.Replace(value) + "'"
+}
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+var envNameTests = []struct {
+	mapper   EnvNameMapper
+	path     string
+	expected string
+}{
+	{EnvNameMapper{"secret/app", "_", true}, "secret/app/db/password", "DB_PASSWORD"},
+	{EnvNameMapper{"/secret/app/", "_", true}, "/secret/app/db/password", "DB_PASSWORD"},
+	{EnvNameMapper{"", "_", true}, "/secret/app/db/password", "SECRET_APP_DB_PASSWORD"},
+	{EnvNameMapper{"secret/app", "__", false}, "secret/app/db/password", "db__password"},
+	{EnvNameMapper{"secret/app", "_", true}, "secret/other/token", "SECRET_OTHER_TOKEN"},
+	{EnvNameMapper{"secret/app", "_", true}, "secret/app/api-key.v2", "API_KEY_V2"},
+	{EnvNameMapper{"secret/app", "_", true}, "secret/app/1st", "_1ST"},
+	{EnvNameMapper{"secret/app", "_", true}, "secret/application/key", "SECRET_APPLICATION_KEY"},
+}
+
+func TestEnvNameMapper_Name(t *testing.T) {
+	for _, tc := range envNameTests {
+		if name := tc.mapper.Name(tc.path); name != tc.expected {
+			t.Fatalf("Expected: '%s' but result was: '%s'\n", tc.expected, name)
+		}
+	}
+}
+
+var envFormatTests = []struct {
+	format   string
+	value    string
+	expected string
+}{
+	{EnvFormatDotenv, "simple", "KEY=simple"},
+	{EnvFormatDotenv, "", "KEY="},
+	{EnvFormatDotenv, "two words", `KEY="two words"`},
+	{EnvFormatDotenv, "line one\nline \"two\"\n", `KEY="line one\nline \"two\"\n"`},
+	{EnvFormatDotenv, `c:\$HOME`, `KEY="c:\\\$HOME"`},
+	{EnvFormatShell, "simple", "export KEY=simple"},
+	{EnvFormatShell, "", "export KEY=''"},
+	{EnvFormatShell, "it's\nhere", "export KEY='it'\\''s\nhere'"},
+	{EnvFormatFish, "simple", "set -gx KEY 'simple'"},
+	{EnvFormatFish, `it's a \ `, `set -gx KEY 'it\'s a \\ '`},
+}
+
+func TestFormatEnv(t *testing.T) {
+	for _, tc := range envFormatTests {
+		line, err := FormatEnv(tc.format, "KEY", tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != tc.expected {
+			t.Fatalf("Expected: '%s' but result was: '%s'\n", tc.expected, line)
+		}
+	}
+	if _, err := FormatEnv("powershell", "KEY", "value"); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+}
+
+func TestEnv_Marshal(t *testing.T) {
+	e := NewEnvEndpoint(NewEnvNameMapper("/secret/app/"), EnvFormatDotenv)
+	e.Visit(core.Secret{Path: "/secret/app/db/user", Value: "admin"})
+	e.Visit(core.Secret{Path: "/secret/app/db/password", Value: "hunter 2"})
+	buf := new(bytes.Buffer)
+	if err := e.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "DB_PASSWORD=\"hunter 2\"\nDB_USER=admin\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, buf.String())
+	}
+}
+
+func TestEnv_Fields(t *testing.T) {
+	e := NewEnvEndpoint(NewEnvNameMapper("/secret/app/"), EnvFormatDotenv)
+	e.Visit(core.Secret{Path: "/secret/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}})
+	e.Visit(core.Secret{Path: "/secret/app/api", Value: "key", Fields: map[string]string{"url": "https://api"}})
+	buf := new(bytes.Buffer)
+	if err := e.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "API=key\nAPI_URL=https://api\nDB_PASSWORD=hunter2\nDB_USER=admin\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, buf.String())
+	}
+}
+
+func TestEnv_Collision(t *testing.T) {
+	e := NewEnvEndpoint(NewEnvNameMapper("/secret/app/"), EnvFormatDotenv)
+	e.Visit(core.Secret{Path: "/secret/app/db/password", Value: "one"})
+	e.Visit(core.Secret{Path: "/secret/app/db-password", Value: "two"})
+	if _, err := e.Vars(); err == nil {
+		t.Fatal("Expected a collision error")
+	}
+	if err := e.Marshal(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected Marshal to refuse colliding names")
+	}
+}
+
+func TestEnvNameMapper_Configure(t *testing.T) {
+	v := getViper("./testdata/syncrets-test1.yml")
+	m := NewEnvNameMapper("/secret/")
+	m.Configure(v, "vault", "vault-a")
+	if name := m.Name("/secret/app/db/password"); name != "db__password" {
+		t.Fatalf("Expected: 'db__password' but result was: '%s'\n", name)
+	}
+	m = NewEnvNameMapper("/secret/")
+	m.Configure(v, "vault", "vault-b")
+	if name := m.Name("/secret/app/db/password"); name != "APP_DB_PASSWORD" {
+		t.Fatalf("Expected: 'APP_DB_PASSWORD' but result was: '%s'\n", name)
+	}
+	v.Set("consul.consul-a.env.separator", "-")
+	m = NewEnvNameMapper("/app/")
+	m.Configure(v, "consul", "consul-a")
+	if name := m.Name("/app/db/password"); name != "DB-PASSWORD" {
+		t.Fatalf("Expected: 'DB-PASSWORD' but result was: '%s'\n", name)
+	}
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CheckOutputDir refuses to write plaintext secrets into a world-readable
+// directory unless allowInsecure is set
+func CheckOutputDir(file string, allowInsecure bool) error {
+	if allowInsecure {
+		return nil
+	}
+	dir := filepath.Dir(file)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		return fmt.Errorf("refusing to write plaintext secrets into world-readable directory %s, use --allow-insecure-output to override", dir)
+	}
+	return nil
+}
+
+// WriteFileAtomic marshals into a 0600 temporary file next to file, syncs it
+// and renames it into place, leaving file untouched if anything fails. The
+// directory is synced too so that the rename survives a crash.
+func WriteFileAtomic(file string, marshal func(io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = marshal(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(file))
+}
+
+// syncDir flushes the entries of a directory to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncrets-atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := dir + "/secrets.json"
+	ioutil.WriteFile(file, []byte("old"), 0600)
+	failed := func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("marshal failed")
+	}
+	if err := WriteFileAtomic(file, failed); err == nil {
+		t.Fatal("Expected the marshal error")
+	}
+	if b, _ := ioutil.ReadFile(file); string(b) != "old" {
+		t.Fatalf("Expected the file to be untouched but found: %s\n", b)
+	}
+	if err := WriteFileAtomic(file, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(file); string(b) != "new" {
+		t.Fatalf("Expected the new contents but found: %s\n", b)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected 0600 permissions but found: %v\n", info.Mode())
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("Expected no temporary files to be left: %v\n", entries)
+	}
+}
+
+func TestCheckOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncrets-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := dir + "/secrets.json"
+	if err := CheckOutputDir(file, false); err != nil {
+		t.Fatalf("Expected a private directory to be accepted: %v\n", err)
+	}
+	os.Chmod(dir, 0755)
+	if err := CheckOutputDir(file, false); err == nil {
+		t.Fatal("Expected a world-readable directory to be refused")
+	}
+	if err := CheckOutputDir(file, true); err != nil {
+		t.Fatalf("Expected --allow-insecure-output to accept the directory: %v\n", err)
+	}
+}
@@ -0,0 +1,363 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/ejson"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// GitScheme selects an encrypted secrets repository, e.g. git+file:///srv/secrets
+const GitScheme = "git+file://"
+
+// fileCipher encrypts the fields of a secret into the contents of a file
+type fileCipher interface {
+	Ext() string
+	Encrypt(data map[string]interface{}) ([]byte, error)
+	Decrypt(b []byte) (map[string]interface{}, error)
+}
+
+// ejsonCipher stores each secret as an EJSON document
+type ejsonCipher struct {
+	publicKey string
+	keydir    string
+}
+
+func (c *ejsonCipher) Ext() string {
+	return ".ejson"
+}
+
+func (c *ejsonCipher) Encrypt(data map[string]interface{}) ([]byte, error) {
+	if c.publicKey == "" {
+		return nil, errors.New("no ejson public key configured")
+	}
+	doc := map[string]interface{}{"_public_key": c.publicKey}
+	for name, value := range data {
+		doc[name] = value
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	if _, err := ejson.Encrypt(bytes.NewReader(b), out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (c *ejsonCipher) Decrypt(b []byte) (map[string]interface{}, error) {
+	out := new(bytes.Buffer)
+	if err := ejson.Decrypt(bytes.NewReader(b), out, c.keydir, ""); err != nil {
+		return nil, err
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &data); err != nil {
+		return nil, err
+	}
+	delete(data, "_public_key")
+	return data, nil
+}
+
+// ejsonKeydir returns the directory holding the ejson private keys
+func ejsonKeydir() string {
+	if keydir := os.Getenv("EJSON_KEYDIR"); keydir != "" {
+		return keydir
+	}
+	return "/opt/ejson/keys"
+}
+
+// GitRepo implements an endpoint storing each secret as an encrypted file in
+// a local git repository, committing the changes made by each sync at once
+type GitRepo struct {
+	origURL *url.URL
+	root    string
+	path    string
+	cipher  fileCipher
+	written []string
+	deleted []string
+	// files holds the files written and deleted since the last commit
+	files map[string]bool
+}
+
+// GetName ...
+func (g *GitRepo) GetName() string {
+	return g.origURL.Host
+}
+
+// GetPath ...
+func (g *GitRepo) GetPath() string {
+	return g.path
+}
+
+// GetURL ...
+func (g *GitRepo) GetURL() *url.URL {
+	return g.origURL
+}
+
+// GetRawURL ...
+func (g *GitRepo) GetRawURL() *url.URL {
+	return g.origURL
+}
+
+// git runs a git command in the repository
+func (g *GitRepo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.root}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func (g *GitRepo) file(path string) (string, error) {
+	file, err := rootedFile(g.root, path)
+	if err != nil {
+		return "", err
+	}
+	return file + g.cipher.Ext(), nil
+}
+
+// Read decrypts the secret at path, nil if there is none
+func (g *GitRepo) Read(path string) (*core.Secret, error) {
+	file, err := g.file(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := g.cipher.Decrypt(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %v", path, err)
+	}
+	secret := core.NewSecret("/"+strings.Trim(path, "/"), data)
+	return &secret, nil
+}
+
+// Write encrypts a secret into its file unless the file already has the same fields
+func (g *GitRepo) Write(secret core.Secret) error {
+	file, err := g.file(secret.Path)
+	if err != nil {
+		return err
+	}
+	data := secret.Data()
+	if b, err := ioutil.ReadFile(file); err == nil {
+		if existing, err := g.cipher.Decrypt(b); err == nil && reflect.DeepEqual(existing, data) {
+			log.Printf("Unchanged %s\n", secret.Path)
+			return nil
+		}
+	}
+	b, err := g.cipher.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	err = WriteFileAtomic(file, func(out io.Writer) error {
+		_, err := out.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	g.track(file)
+	g.written = append(g.written, secret.Path)
+	return nil
+}
+
+// Delete ...
+func (g *GitRepo) Delete(secret core.Secret) error {
+	file, err := g.file(secret.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(file); dir != filepath.Clean(g.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	g.track(file)
+	g.deleted = append(g.deleted, secret.Path)
+	return nil
+}
+
+// track a file to be staged by the next commit
+func (g *GitRepo) track(file string) {
+	if g.files == nil {
+		g.files = make(map[string]bool)
+	}
+	g.files[file] = true
+}
+
+// stage the tracked files only, leaving anything else in the work tree alone
+func (g *GitRepo) stage() error {
+	var added, removed []string
+	for file := range g.files {
+		if _, err := os.Stat(file); err == nil {
+			added = append(added, file)
+		} else {
+			removed = append(removed, file)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if len(added) > 0 {
+		if _, err := g.git(append([]string{"add", "--"}, added...)...); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		// files written and deleted again since the last commit are not in the index
+		if _, err := g.git(append([]string{"rm", "-q", "--cached", "--ignore-unmatch", "--"}, removed...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit the secrets written and deleted since the last commit
+func (g *GitRepo) Commit(summary string) error {
+	if len(g.written) == 0 && len(g.deleted) == 0 {
+		log.Printf("Nothing to commit in %s\n", g.root)
+		return nil
+	}
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "%s (%d written, %d deleted)\n\n", summary, len(g.written), len(g.deleted))
+	for _, path := range g.written {
+		fmt.Fprintf(msg, "write %s\n", path)
+	}
+	for _, path := range g.deleted {
+		fmt.Fprintf(msg, "delete %s\n", path)
+	}
+	if err := g.stage(); err != nil {
+		return err
+	}
+	if _, err := g.git("commit", "-q", "-m", msg.String()); err != nil {
+		return err
+	}
+	g.written, g.deleted, g.files = nil, nil, nil
+	return nil
+}
+
+// Walk the secrets...
+func (g *GitRepo) Walk(visitor core.Visitor) {
+	start, err := rootedFile(g.root, g.path)
+	if err != nil {
+		start = g.root
+	}
+	err = filepath.Walk(start, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == start && os.IsNotExist(err) {
+				// nothing stored under the prefix yet
+				return nil
+			}
+			log.Printf("   -> walk error: %v\n", err)
+			core.ReportError(visitor, file, err)
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(file, g.cipher.Ext()) {
+			return nil
+		}
+		rel, err := filepath.Rel(g.root, strings.TrimSuffix(file, g.cipher.Ext()))
+		if err != nil {
+			return err
+		}
+		path := "/" + filepath.ToSlash(rel)
+		b, err := ioutil.ReadFile(file)
+		if err == nil {
+			var data map[string]interface{}
+			if data, err = g.cipher.Decrypt(b); err == nil {
+				visitor.Visit(core.NewSecret(path, data))
+				log.Printf("       <- visited path=%s\n", path)
+				return nil
+			}
+		}
+		log.Printf("       !! err: %s: %v\n", path, err)
+		core.ReportError(visitor, path, err)
+		return nil
+	})
+	if err != nil {
+		log.Printf("   -> walk error: %v\n", err)
+		core.ReportError(visitor, g.path, err)
+	}
+}
+
+// NewGitBackend returns a git repository endpoint for a git+file:// URL,
+// initializing the repository if needed. The path query parameter restricts
+// Walk to a prefix, encryption selects ejson (default) or age and public_key
+// overrides the ejson public key with a key or the name of one in ejson.keys.
+func NewGitBackend(v *viper.Viper, args []string) (*GitRepo, error) {
+	if len(args) < 1 {
+		return nil, errors.New("source argument is missing")
+	}
+	u := core.ParseURL(args[0])
+	if u == nil {
+		return nil, errors.New("cannot parse url")
+	}
+	g := &GitRepo{origURL: u, root: u.Host + u.Path}
+	if g.root == "" {
+		return nil, fmt.Errorf("missing repository directory in %s", args[0])
+	}
+	query := u.Query()
+	if g.path = query.Get("path"); g.path == "" {
+		g.path = "/"
+	}
+	switch encryption := query.Get("encryption"); encryption {
+	case "", "ejson":
+		publicKey := v.GetString("ejson.public_key")
+		if selector := query.Get("public_key"); selector != "" {
+			var err error
+			if publicKey, err = ResolveEJSONPublicKey(v, selector); err != nil {
+				return nil, err
+			}
+		}
+		g.cipher = &ejsonCipher{publicKey: publicKey, keydir: ejsonKeydir()}
+	case "age":
+		keys, err := NewAgeKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		g.cipher = &ageCipher{keys}
+	default:
+		return nil, fmt.Errorf("unknown encryption '%s', expected ejson or age", encryption)
+	}
+	if _, err := os.Stat(filepath.Join(g.root, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(g.root, 0700); err != nil {
+			return nil, err
+		}
+		log.Printf("Initializing git repository in %s\n", g.root)
+		if _, err := g.git("init", "-q"); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
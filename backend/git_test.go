@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// setupEJSONKeys generates an ejson keypair in a temporary EJSON_KEYDIR
+func setupEJSONKeys(t *testing.T) (string, string) {
+	pub, priv, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keydir, err := ioutil.TempDir("", "syncrets-ejson-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keydir, pub), []byte(priv), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("EJSON_KEYDIR", keydir)
+	return pub, keydir
+}
+
+func setupGitRepo(t *testing.T) (*GitRepo, string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		os.Setenv(name, "syncrets test")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		os.Setenv(name, "syncrets@example.com")
+	}
+	pub, keydir := setupEJSONKeys(t)
+	root, err := ioutil.TempDir("", "syncrets-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.Set("ejson.public_key", pub)
+	g, err := NewGitBackend(v, []string{GitScheme + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, root, keydir
+}
+
+func gitLog(t *testing.T, g *GitRepo) []string {
+	out, err := g.git("log", "--format=%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(out), "\n")
+}
+
+func TestGitRepo_WriteCommitWalk(t *testing.T) {
+	g, root, keydir := setupGitRepo(t)
+	defer os.RemoveAll(root)
+	defer os.RemoveAll(keydir)
+	secrets := []core.Secret{
+		{Path: "/secret/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+		{Path: "/secret/app/token", Value: "t0ken"},
+	}
+	for _, s := range secrets {
+		if err := g.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Commit("syncrets sync test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "secret", "app", "db.ejson")); err != nil {
+		t.Fatal(err)
+	}
+	if subjects := gitLog(t, g); !reflect.DeepEqual(subjects, []string{"syncrets sync test (2 written, 0 deleted)"}) {
+		t.Fatalf("Unexpected commits: %v\n", subjects)
+	}
+	c := &collector{}
+	g.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+
+	// unchanged secrets don't make a new commit
+	for _, s := range secrets {
+		g.Write(s)
+	}
+	g.Commit("syncrets sync again")
+	if subjects := gitLog(t, g); len(subjects) != 1 {
+		t.Fatalf("Expected no new commit but found: %v\n", subjects)
+	}
+
+	if err := g.Delete(secrets[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Commit("syncrets rm test"); err != nil {
+		t.Fatal(err)
+	}
+	if subjects := gitLog(t, g); subjects[0] != "syncrets rm test (0 written, 1 deleted)" {
+		t.Fatalf("Unexpected commits: %v\n", subjects)
+	}
+}
+
+func TestGitRepo_CommitStagesOwnFiles(t *testing.T) {
+	g, root, keydir := setupGitRepo(t)
+	defer os.RemoveAll(root)
+	defer os.RemoveAll(keydir)
+	ioutil.WriteFile(filepath.Join(root, "notes.txt"), []byte("not a secret"), 0600)
+	g.Write(core.Secret{Path: "/secret/app/token", Value: "t0ken"})
+	g.Write(core.Secret{Path: "/secret/app/tmp", Value: "gone"})
+	g.Delete(core.Secret{Path: "/secret/app/tmp"})
+	if err := g.Commit("syncrets sync test"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := g.git("status", "--porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out) != "?? notes.txt" {
+		t.Fatalf("Expected only the unrelated file to be left uncommitted: %s\n", out)
+	}
+}
@@ -5,24 +5,62 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strings"
 
 	"github.com/drmdrew/syncrets/core"
 )
 
+// JSON export layouts: nested splits paths into nested objects, flat keeps
+// each full path as a key of the secrets object so any path round-trips
+const (
+	JSONLayoutNested = "nested"
+	JSONLayoutFlat   = "flat"
+)
+
+// jsonDocumentVersion is the version of the syncrets header of exported documents
+const jsonDocumentVersion = 1
+
+// jsonHeader tells importers which layout a document uses, documents
+// without a header are a nested tree of secrets
+type jsonHeader struct {
+	Version int    `json:"version"`
+	Layout  string `json:"layout"`
+}
+
+// jsonNestedDocument is a document in the nested layout
+type jsonNestedDocument struct {
+	Syncrets jsonHeader             `json:"syncrets"`
+	Secrets  map[string]interface{} `json:"secrets"`
+}
+
+// jsonFlatDocument is a document in the flat layout
+type jsonFlatDocument struct {
+	Syncrets jsonHeader                        `json:"syncrets"`
+	Secrets  map[string]map[string]interface{} `json:"secrets"`
+}
+
 // JSONEndpoint ...
 type JSONEndpoint struct {
-	kv map[string]interface{}
+	kv      map[string]interface{}
+	secrets map[string]core.Secret
+	Layout  string
+	fields  nestedFields
 }
 
 // NewJSONEndpoint ...
 func NewJSONEndpoint() *JSONEndpoint {
-	return &JSONEndpoint{make(map[string]interface{})}
+	return &JSONEndpoint{kv: make(map[string]interface{}), secrets: make(map[string]core.Secret), Layout: JSONLayoutNested}
 }
 
 // AddSecretToKV ...
 func AddSecretToKV(s core.Secret, kv map[string]interface{}) {
-	steps := strings.Split(s.Path, "/")
+	addKVValue(s.Path, s.Value, kv)
+}
+
+// addKVValue adds a leaf value of any type at path to a nested kv map
+func addKVValue(path string, value interface{}, kv map[string]interface{}) {
+	steps := strings.Split(path, "/")
 	for _, step := range steps[:len(steps)-1] {
 		if step == "" {
 			continue
@@ -40,17 +78,106 @@ func AddSecretToKV(s core.Secret, kv map[string]interface{}) {
 		}
 	}
 	lastStep := steps[len(steps)-1]
-	kv[lastStep] = s.Value
+	if m, ok := kv[lastStep].(map[string]interface{}); ok {
+		// the path is also a prefix of secrets added before
+		m["."] = value
+		return
+	}
+	kv[lastStep] = value
+}
+
+// WalkKV visits every secret in a nested kv map built by AddSecretToKV
+func WalkKV(kv map[string]interface{}, visitor core.Visitor) {
+	walkKV("", kv, visitor)
+}
+
+func walkKV(prefix string, kv map[string]interface{}, visitor core.Visitor) {
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path := prefix + "/" + key
+		if key == "." {
+			// a prefix that is also a leaf keeps its value under "."
+			path = prefix
+		}
+		switch value := kv[key].(type) {
+		case map[string]interface{}:
+			walkKV(path, value, visitor)
+		case string:
+			visitor.Visit(core.Secret{Path: path, Value: value})
+		case nil:
+			log.Printf("Skipping empty value at %s\n", path)
+		default:
+			visitor.Visit(core.Secret{Path: path, Value: fmt.Sprintf("%v", value)})
+		}
+	}
+}
+
+// nestedFields records the secrets with other fields than value, which a
+// nested kv map cannot hold next to the secrets below their path
+type nestedFields []string
+
+func (f *nestedFields) add(s core.Secret) {
+	if len(s.Fields) > 0 {
+		*f = append(*f, s.Path)
+	}
+}
+
+// err fails the export rather than silently dropping the fields
+func (f nestedFields) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	paths := append([]string{}, f...)
+	sort.Strings(paths)
+	return fmt.Errorf("%d secrets have several fields, which a nested file cannot hold (a flat .json file can): %s", len(paths), strings.Join(paths, ", "))
 }
 
 // Visit ...
 func (j *JSONEndpoint) Visit(s core.Secret) {
 	AddSecretToKV(s, j.kv)
+	j.fields.add(s)
+	j.secrets[s.Path] = s
+}
+
+// Walk the secrets read by Unmarshal
+func (j *JSONEndpoint) Walk(visitor core.Visitor) {
+	if j.Layout != JSONLayoutFlat {
+		WalkKV(j.kv, visitor)
+		return
+	}
+	paths := make([]string, 0, len(j.secrets))
+	for path := range j.secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		visitor.Visit(j.secrets[path])
+	}
 }
 
 // Marshal ...
 func (j *JSONEndpoint) Marshal(out io.Writer) error {
-	b, err := json.Marshal(j.kv)
+	var doc interface{}
+	if j.Layout != JSONLayoutFlat {
+		if err := j.fields.err(); err != nil {
+			return err
+		}
+		doc = jsonNestedDocument{jsonHeader{jsonDocumentVersion, JSONLayoutNested}, j.kv}
+	} else {
+		flat := jsonFlatDocument{
+			Syncrets: jsonHeader{jsonDocumentVersion, JSONLayoutFlat},
+			Secrets:  make(map[string]map[string]interface{}, len(j.secrets)),
+		}
+		for path, secret := range j.secrets {
+			flat.Secrets[path] = secret.Data()
+		}
+		doc = flat
+	}
+	b, err := json.Marshal(doc)
 	if err != nil {
 		log.Print(err)
 		return err
@@ -58,3 +185,120 @@ func (j *JSONEndpoint) Marshal(out io.Writer) error {
 	fmt.Fprintf(out, "%s\n", string(b[:]))
 	return nil
 }
+
+// decodeJSON decodes a document keeping its numbers as they were written,
+// so that merged files keep the values they do not sync
+func decodeJSON(in io.Reader, v interface{}) error {
+	d := json.NewDecoder(in)
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// readJSONDocument returns the layout of a document with its secrets as a
+// nested kv map or, for the flat layout, by path
+func readJSONDocument(in io.Reader) (string, map[string]interface{}, map[string]core.Secret, error) {
+	kv := make(map[string]interface{})
+	if err := decodeJSON(in, &kv); err != nil {
+		return "", nil, nil, err
+	}
+	header, ok := readJSONHeader(kv)
+	if !ok {
+		return JSONLayoutNested, kv, nil, nil
+	}
+	if header.Version > jsonDocumentVersion {
+		return "", nil, nil, fmt.Errorf("unsupported syncrets document version %d", header.Version)
+	}
+	tree, _ := kv["secrets"].(map[string]interface{})
+	switch header.Layout {
+	case JSONLayoutNested:
+		if tree == nil {
+			tree = make(map[string]interface{})
+		}
+		return JSONLayoutNested, tree, nil, nil
+	case JSONLayoutFlat:
+		secrets := make(map[string]core.Secret)
+		for path, value := range tree {
+			data, ok := value.(map[string]interface{})
+			if !ok {
+				return "", nil, nil, fmt.Errorf("secret %s is not an object of fields", path)
+			}
+			secrets[path] = core.NewSecret(path, data)
+		}
+		return JSONLayoutFlat, nil, secrets, nil
+	}
+	return "", nil, nil, fmt.Errorf("unknown syncrets document layout '%s'", header.Layout)
+}
+
+// readJSONHeader returns the header of a document holding nothing but a
+// syncrets header and a secrets object. Any other document is a nested tree,
+// even with a top-level syncrets key.
+func readJSONHeader(doc map[string]interface{}) (jsonHeader, bool) {
+	for key, value := range doc {
+		if _, ok := value.(map[string]interface{}); !ok || (key != "syncrets" && key != "secrets") {
+			return jsonHeader{}, false
+		}
+	}
+	header, _ := doc["syncrets"].(map[string]interface{})
+	if len(header) != 2 {
+		return jsonHeader{}, false
+	}
+	number, _ := header["version"].(json.Number)
+	version, err := number.Int64()
+	layout, ok := header["layout"].(string)
+	if err != nil || version < 1 || !ok {
+		return jsonHeader{}, false
+	}
+	return jsonHeader{int(version), layout}, true
+}
+
+// Unmarshal reads a document in either layout
+func (j *JSONEndpoint) Unmarshal(in io.Reader) error {
+	layout, kv, secrets, err := readJSONDocument(in)
+	if err != nil {
+		return err
+	}
+	j.Layout = layout
+	if layout == JSONLayoutFlat {
+		j.secrets = secrets
+	} else {
+		j.kv = kv
+	}
+	return nil
+}
+
+// Merge overlays the visited secrets onto an existing JSON file in either
+// layout, see MergeKV
+func (j *JSONEndpoint) Merge(in io.Reader, prefix string, prune bool) error {
+	layout, existing, secrets, err := readJSONDocument(in)
+	if err != nil {
+		return err
+	}
+	if j.Layout != JSONLayoutFlat {
+		if layout == JSONLayoutFlat {
+			// the nested layout cannot hold every flat path nor the fields of a secret
+			return fmt.Errorf("cannot merge into a document in the flat layout, use --json-layout flat")
+		}
+		j.kv = MergeKV(existing, existing, j.kv, prefix, prune)
+		return nil
+	}
+	if layout != JSONLayoutFlat {
+		var l core.SecretList
+		WalkKV(existing, &l)
+		secrets = make(map[string]core.Secret, len(l))
+		for _, s := range l {
+			secrets[s.Path] = s
+		}
+	}
+	if prune {
+		for path := range secrets {
+			if _, ok := j.secrets[path]; !ok && UnderPrefix(path, prefix) {
+				delete(secrets, path)
+			}
+		}
+	}
+	for path, s := range j.secrets {
+		secrets[path] = s
+	}
+	j.secrets = secrets
+	return nil
+}
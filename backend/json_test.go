@@ -2,6 +2,7 @@ package backend
 
 import (
 	"bytes"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -12,12 +13,12 @@ var jsonTests = []struct {
 	secrets  []core.Secret
 	expected string
 }{
-	{[]core.Secret{core.Secret{"secret/citizen", "four"}},
-		`{"secret":{"citizen":"four"}}`},
-	{[]core.Secret{core.Secret{"secret/citizen/kane", "Rosebud"}},
-		`{"secret":{"citizen":{"kane":"Rosebud"}}}`},
-	{[]core.Secret{core.Secret{"secret/citizen", "four"}, core.Secret{"secret/citizen/kane", "Rosebud"}},
-		`{"secret":{"citizen":{".":"four","kane":"Rosebud"}}}`},
+	{[]core.Secret{core.Secret{Path: "secret/citizen", Value: "four"}},
+		`{"syncrets":{"version":1,"layout":"nested"},"secrets":{"secret":{"citizen":"four"}}}`},
+	{[]core.Secret{core.Secret{Path: "secret/citizen/kane", Value: "Rosebud"}},
+		`{"syncrets":{"version":1,"layout":"nested"},"secrets":{"secret":{"citizen":{"kane":"Rosebud"}}}}`},
+	{[]core.Secret{core.Secret{Path: "secret/citizen", Value: "four"}, core.Secret{Path: "secret/citizen/kane", Value: "Rosebud"}},
+		`{"syncrets":{"version":1,"layout":"nested"},"secrets":{"secret":{"citizen":{".":"four","kane":"Rosebud"}}}}`},
 }
 
 func TestJSON_Marshal(t *testing.T) {
@@ -35,3 +36,97 @@ func TestJSON_Marshal(t *testing.T) {
 		}
 	}
 }
+
+func TestJSON_FlatRoundTrip(t *testing.T) {
+	secrets := []core.Secret{
+		{Path: "/secret//empty", Value: "a"},
+		{Path: "/secret/app/.", Value: "dot"},
+		{Path: "/secret/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+		{Path: "secret/relative", Value: "b"},
+	}
+	out := NewJSONEndpoint()
+	out.Layout = JSONLayoutFlat
+	for _, s := range secrets {
+		out.Visit(s)
+	}
+	buf := new(bytes.Buffer)
+	if err := out.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), `{"syncrets":{"version":1,"layout":"flat"},"secrets":{"/secret//empty":{"value":"a"}`) {
+		t.Fatalf("Unexpected flat document: %s\n", buf.String())
+	}
+	in := NewJSONEndpoint()
+	if err := in.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	in.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+}
+
+func TestJSON_UnmarshalLayouts(t *testing.T) {
+	expected := []core.Secret{{Path: "/secret/foo", Value: "bar"}}
+	docs := []string{
+		`{"secret":{"foo":"bar"}}`,
+		`{"syncrets":{"version":1,"layout":"nested"},"secrets":{"secret":{"foo":"bar"}}}`,
+		`{"syncrets":{"version":1,"layout":"flat"},"secrets":{"/secret/foo":{"value":"bar"}}}`,
+	}
+	for _, doc := range docs {
+		in := NewJSONEndpoint()
+		if err := in.Unmarshal(strings.NewReader(doc)); err != nil {
+			t.Fatal(err)
+		}
+		c := &collector{}
+		in.Walk(c)
+		if !reflect.DeepEqual(c.secrets, expected) {
+			t.Fatalf("%s: expected: %v but result was: %v\n", doc, expected, c.secrets)
+		}
+	}
+	in := NewJSONEndpoint()
+	if err := in.Unmarshal(strings.NewReader(`{"syncrets":{"version":2,"layout":"flat"}}`)); err == nil {
+		t.Fatal("Expected an error for an unsupported version")
+	}
+
+	// a tree with a top-level syncrets key is not mistaken for a header
+	in = NewJSONEndpoint()
+	if err := in.Unmarshal(strings.NewReader(`{"syncrets":{"layout":"flat","token":"t"},"secrets":{"db":"x"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	in.Walk(c)
+	tree := []core.Secret{
+		{Path: "/secrets/db", Value: "x"},
+		{Path: "/syncrets/layout", Value: "flat"},
+		{Path: "/syncrets/token", Value: "t"},
+	}
+	if in.Layout != JSONLayoutNested || !reflect.DeepEqual(c.secrets, tree) {
+		t.Fatalf("Expected the nested tree: %v\n", c.secrets)
+	}
+}
+
+func TestJSON_NestedRoundTrip(t *testing.T) {
+	secrets := []core.Secret{
+		{Path: "/syncrets/layout", Value: "flat"},
+		{Path: "/syncrets/version", Value: "1"},
+	}
+	out := NewJSONEndpoint()
+	for _, s := range secrets {
+		out.Visit(s)
+	}
+	buf := new(bytes.Buffer)
+	if err := out.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	in := NewJSONEndpoint()
+	if err := in.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	in.Walk(c)
+	if in.Layout != JSONLayoutNested || !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+}
@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"gopkg.in/yaml.v3"
+)
+
+// K8sScheme selects a Kubernetes Secret manifest file, e.g. k8s://app-secret.yaml?namespace=prod
+const K8sScheme = "k8s://"
+
+// k8sPrefixAnnotation records the path prefix stripped from the secret paths
+const k8sPrefixAnnotation = "syncrets/prefix"
+
+// k8sSourcesAnnotation records the secret path and field of the data keys
+// that do not read back as the prefix followed by the key
+const k8sSourcesAnnotation = "syncrets/sources"
+
+var (
+	k8sInvalidKeyChars  = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+	k8sInvalidNameChars = regexp.MustCompile(`[^-.a-z0-9]`)
+)
+
+type k8sMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type k8sSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	StringData map[string]string `yaml:"stringData,omitempty"`
+}
+
+// k8sSource is the secret path and field a data key was made from
+type k8sSource struct {
+	Path  string `json:"path"`
+	Field string `json:"field,omitempty"`
+}
+
+// K8sEndpoint reads and writes secrets as a Kubernetes Secret manifest
+type K8sEndpoint struct {
+	// Name of the Secret, defaults to the manifest file name
+	Name      string
+	Namespace string
+	Labels    map[string]string
+	// Prefix is stripped from secret paths to make the data keys
+	Prefix string
+	// Separator replaces the "/" between the remaining path steps
+	Separator string
+	data      map[string]string
+	paths     map[string][]string
+	sources   map[string]k8sSource
+}
+
+// NewK8sEndpoint ...
+func NewK8sEndpoint(name string) *K8sEndpoint {
+	return &K8sEndpoint{
+		Name:      name,
+		Labels:    make(map[string]string),
+		Separator: "_",
+		data:      make(map[string]string),
+		paths:     make(map[string][]string),
+		sources:   make(map[string]k8sSource),
+	}
+}
+
+// ParseK8sURL returns the manifest file and an endpoint configured from a k8s:// URL.
+// The name, namespace, prefix and separator query parameters and any number of
+// label=key=value parameters configure the manifest.
+func ParseK8sURL(raw string) (string, *K8sEndpoint, error) {
+	file := strings.TrimPrefix(raw, K8sScheme)
+	query := ""
+	if i := strings.Index(file, "?"); i >= 0 {
+		file, query = file[:i], file[i+1:]
+	}
+	if file == "" {
+		return "", nil, fmt.Errorf("missing file in %s", raw)
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, err
+	}
+	base := filepath.Base(file)
+	k := NewK8sEndpoint(strings.TrimSuffix(base, filepath.Ext(base)))
+	if name := params.Get("name"); name != "" {
+		k.Name = name
+	}
+	k.Namespace = params.Get("namespace")
+	k.Prefix = params.Get("prefix")
+	if sep, ok := params["separator"]; ok {
+		k.Separator = sep[0]
+	}
+	for _, label := range params["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("label must be key=value: %s", label)
+		}
+		k.Labels[kv[0]] = kv[1]
+	}
+	return file, k, nil
+}
+
+// Key returns the data key for a secret path
+func (k *K8sEndpoint) Key(path string) string {
+	prefix := strings.Trim(k.Prefix, "/")
+	path = strings.Trim(path, "/")
+	if prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+		path = strings.TrimPrefix(path[len(prefix):], "/")
+	}
+	var steps []string
+	for _, step := range strings.Split(path, "/") {
+		if step != "" {
+			steps = append(steps, k8sInvalidKeyChars.ReplaceAllString(step, "_"))
+		}
+	}
+	return strings.Join(steps, k.Separator)
+}
+
+// Visit ...
+func (k *K8sEndpoint) Visit(s core.Secret) {
+	for field, value := range s.Data() {
+		// other fields than value are keyed by the path and the field
+		path := s.Path
+		source := k8sSource{Path: s.Path}
+		if field != core.ValueField {
+			path += "/" + field
+			source.Field = field
+		}
+		key := k.Key(path)
+		k.data[key] = value.(string)
+		k.paths[key] = append(k.paths[key], path)
+		k.sources[key] = source
+	}
+}
+
+// keyPath is the secret path of a data key without a recorded source
+func (k *K8sEndpoint) keyPath(key string) string {
+	prefix := "/" + strings.Trim(k.Prefix, "/")
+	if prefix != "/" {
+		prefix += "/"
+	}
+	return prefix + key
+}
+
+// Walk the secrets read by Unmarshal. Data keys with a recorded source are
+// fields of their secret, any other key is a secret under Prefix.
+func (k *K8sEndpoint) Walk(visitor core.Visitor) {
+	fields := make(map[string]map[string]interface{})
+	for key, value := range k.data {
+		source, ok := k.sources[key]
+		if !ok {
+			source = k8sSource{Path: k.keyPath(key)}
+		}
+		if source.Field == "" {
+			source.Field = core.ValueField
+		}
+		if fields[source.Path] == nil {
+			fields[source.Path] = make(map[string]interface{})
+		}
+		fields[source.Path][source.Field] = value
+	}
+	paths := make([]string, 0, len(fields))
+	for path := range fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		visitor.Visit(core.NewSecret(path, fields[path]))
+	}
+}
+
+// Data returns the collected data or an error if any keys collide
+func (k *K8sEndpoint) Data() (map[string]string, error) {
+	var collisions []string
+	for key, paths := range k.paths {
+		if key == "" {
+			collisions = append(collisions, fmt.Sprintf("%v map to an empty key", paths))
+		} else if len(paths) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%v all map to %s", paths, key))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("secret data key collision: %s", strings.Join(collisions, "; "))
+	}
+	return k.data, nil
+}
+
+// Marshal writes an Opaque Secret manifest with base64 encoded data
+func (k *K8sEndpoint) Marshal(out io.Writer) error {
+	data, err := k.Data()
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	manifest := k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: k8sMetadata{
+			Name:      k8sInvalidNameChars.ReplaceAllString(strings.ToLower(k.Name), "-"),
+			Namespace: k.Namespace,
+			Labels:    k.Labels,
+		},
+		Type: "Opaque",
+		Data: make(map[string]string, len(data)),
+	}
+	annotations := make(map[string]string)
+	if k.Prefix != "" {
+		annotations[k8sPrefixAnnotation] = k.Prefix
+	}
+	sources := make(map[string]k8sSource)
+	for key, source := range k.sources {
+		if source.Field != "" || source.Path != k.keyPath(key) {
+			sources[key] = source
+		}
+	}
+	if len(sources) > 0 {
+		b, err := json.Marshal(sources)
+		if err != nil {
+			return err
+		}
+		annotations[k8sSourcesAnnotation] = string(b)
+	}
+	if len(annotations) > 0 {
+		manifest.Metadata.Annotations = annotations
+	}
+	for key, value := range data {
+		manifest.Data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&manifest); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	return enc.Close()
+}
+
+// IsK8sManifest reports whether a YAML document is a Kubernetes Secret manifest
+func IsK8sManifest(b []byte) bool {
+	var manifest struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+	}
+	if err := yaml.Unmarshal(b, &manifest); err != nil {
+		return false
+	}
+	return manifest.APIVersion != "" && manifest.Kind == "Secret"
+}
+
+// Unmarshal reads the data and stringData of a Secret manifest
+func (k *K8sEndpoint) Unmarshal(in io.Reader) error {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var manifest k8sSecret
+	if err := yaml.Unmarshal(b, &manifest); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	if manifest.Kind != "Secret" {
+		return fmt.Errorf("expected a Secret manifest but found kind '%s'", manifest.Kind)
+	}
+	if k.Prefix == "" {
+		k.Prefix = manifest.Metadata.Annotations[k8sPrefixAnnotation]
+	}
+	k.sources = make(map[string]k8sSource)
+	if sources, ok := manifest.Metadata.Annotations[k8sSourcesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(sources), &k.sources); err != nil {
+			return fmt.Errorf("invalid %s annotation: %v", k8sSourcesAnnotation, err)
+		}
+	}
+	k.Name = manifest.Metadata.Name
+	k.Namespace = manifest.Metadata.Namespace
+	k.Labels = manifest.Metadata.Labels
+	k.data = make(map[string]string, len(manifest.Data)+len(manifest.StringData))
+	for key, value := range manifest.Data {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("cannot decode data key '%s': %v", key, err)
+		}
+		k.data[key] = string(decoded)
+	}
+	// stringData takes precedence over data, as it does in Kubernetes
+	for key, value := range manifest.StringData {
+		k.data[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+func TestParseK8sURL(t *testing.T) {
+	file, k, err := ParseK8sURL("k8s://deploy/app-secret.yaml?namespace=prod&label=team=web&label=tier=db&separator=.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != "deploy/app-secret.yaml" {
+		t.Fatalf("Expected file 'deploy/app-secret.yaml' but was: '%s'\n", file)
+	}
+	if k.Name != "app-secret" || k.Namespace != "prod" || k.Separator != "." {
+		t.Fatalf("Unexpected settings: %+v\n", k)
+	}
+	expected := map[string]string{"team": "web", "tier": "db"}
+	if !reflect.DeepEqual(k.Labels, expected) {
+		t.Fatalf("Expected labels %v but was: %v\n", expected, k.Labels)
+	}
+	if _, _, err := ParseK8sURL("k8s://app.yaml?label=team"); err == nil {
+		t.Fatal("Expected an error for a label without a value")
+	}
+}
+
+func TestK8s_Marshal(t *testing.T) {
+	k := NewK8sEndpoint("App_Secret")
+	k.Namespace = "prod"
+	k.Labels["team"] = "web"
+	k.Prefix = "/secret/app/"
+	k.Visit(core.Secret{Path: "/secret/app/password", Value: "hunter2"})
+	k.Visit(core.Secret{Path: "/secret/app/tls/ca.crt", Value: "cert\n"})
+	buf := new(bytes.Buffer)
+	if err := k.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app-secret
+  namespace: prod
+  labels:
+    team: web
+  annotations:
+    syncrets/prefix: /secret/app/
+    syncrets/sources: '{"tls_ca.crt":{"path":"/secret/app/tls/ca.crt"}}'
+type: Opaque
+data:
+  password: aHVudGVyMg==
+  tls_ca.crt: Y2VydAo=
+`
+	if buf.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, buf.String())
+	}
+}
+
+func TestK8s_Collision(t *testing.T) {
+	k := NewK8sEndpoint("app")
+	k.Prefix = "/secret/app/"
+	k.Visit(core.Secret{Path: "/secret/app/db/password", Value: "one"})
+	k.Visit(core.Secret{Path: "/secret/app/db_password", Value: "two"})
+	if err := k.Marshal(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected Marshal to refuse colliding keys")
+	}
+}
+
+func TestK8s_Fields(t *testing.T) {
+	k := NewK8sEndpoint("app")
+	k.Prefix = "/secret/app/"
+	k.Visit(core.Secret{Path: "/secret/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}})
+	data, err := k.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"db_user": "admin", "db_password": "hunter2"}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, data)
+	}
+}
+
+func TestK8s_Unmarshal(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  annotations:
+    syncrets/prefix: /secret/app/
+data:
+  password: aHVudGVyMg==
+stringData:
+  user: admin
+`
+	k := NewK8sEndpoint("")
+	if err := k.Unmarshal(strings.NewReader(manifest)); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	k.Walk(c)
+	expected := []core.Secret{
+		{Path: "/secret/app/password", Value: "hunter2"},
+		{Path: "/secret/app/user", Value: "admin"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+	if err := NewK8sEndpoint("").Unmarshal(strings.NewReader("kind: ConfigMap\n")); err == nil {
+		t.Fatal("Expected an error reading a ConfigMap")
+	}
+}
+
+func TestK8s_RoundTrip(t *testing.T) {
+	secrets := []core.Secret{
+		{Path: "/secret/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+		{Path: "/secret/app/password", Value: "s3cret"},
+		{Path: "/secret/app/tls/ca.crt", Value: "cert\n"},
+	}
+	k := NewK8sEndpoint("app")
+	k.Prefix = "/secret/app/"
+	for _, s := range secrets {
+		k.Visit(s)
+	}
+	buf := new(bytes.Buffer)
+	if err := k.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !IsK8sManifest(buf.Bytes()) || IsK8sManifest([]byte("kind: Secret\npassword: x\n")) {
+		t.Fatal("Expected only a Secret manifest to be detected")
+	}
+	read := NewK8sEndpoint("")
+	if err := read.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	read.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+}
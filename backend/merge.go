@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// kvLeaves returns the leaf values of a nested kv map by path, whatever
+// their type, empty objects included
+func kvLeaves(kv map[string]interface{}) map[string]interface{} {
+	leaves := make(map[string]interface{})
+	addKVLeaves("", kv, leaves)
+	return leaves
+}
+
+func addKVLeaves(prefix string, kv map[string]interface{}, leaves map[string]interface{}) {
+	for key, value := range kv {
+		path := prefix + "/" + key
+		if key == "." {
+			path = prefix
+		}
+		if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+			addKVLeaves(path, m, leaves)
+			continue
+		}
+		leaves[path] = value
+	}
+}
+
+// UnderPrefix reports whether a secret path is the prefix or below it
+func UnderPrefix(path string, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	path = strings.Trim(path, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// MergeKV overlays the secrets of kv onto the existing kv map of a file.
+// plain holds the decrypted existing values (existing itself for plaintext
+// files): existing values whose plaintext did not change are kept as they
+// are, so encrypted values are not re-encrypted. Existing values that are
+// not synced keep their type, numbers, booleans and nulls included. With
+// prune, existing secrets under prefix that are not in kv are deleted.
+func MergeKV(existing map[string]interface{}, plain map[string]interface{}, kv map[string]interface{}, prefix string, prune bool) map[string]interface{} {
+	values := kvLeaves(existing)
+	plainValues := kvLeaves(plain)
+	walked := kvLeaves(kv)
+	if prune {
+		for path := range values {
+			if _, ok := walked[path]; !ok && UnderPrefix(path, prefix) {
+				delete(values, path)
+			}
+		}
+	}
+	for path, value := range walked {
+		if old, ok := plainValues[path]; ok && reflect.DeepEqual(old, value) {
+			if _, ok := values[path]; ok {
+				continue
+			}
+		}
+		values[path] = value
+	}
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	merged := make(map[string]interface{})
+	for _, path := range paths {
+		value := values[path]
+		if m, ok := value.(map[string]interface{}); ok {
+			// a copy of an empty object, the existing map is left alone
+			value = make(map[string]interface{}, len(m))
+		}
+		addKVValue(path, value, merged)
+	}
+	return merged
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+func kvOf(secrets ...core.Secret) map[string]interface{} {
+	kv := make(map[string]interface{})
+	for _, s := range secrets {
+		AddSecretToKV(s, kv)
+	}
+	return kv
+}
+
+func TestMergeKV(t *testing.T) {
+	existing := kvOf(
+		core.Secret{Path: "/other/keep", Value: "EJ[kept]"},
+		core.Secret{Path: "/secret/app/same", Value: "EJ[same]"},
+		core.Secret{Path: "/secret/app/changed", Value: "EJ[old]"},
+		core.Secret{Path: "/secret/app/missing", Value: "EJ[missing]"},
+	)
+	plain := kvOf(
+		core.Secret{Path: "/other/keep", Value: "kept"},
+		core.Secret{Path: "/secret/app/same", Value: "same"},
+		core.Secret{Path: "/secret/app/changed", Value: "old"},
+		core.Secret{Path: "/secret/app/missing", Value: "missing"},
+	)
+	walked := kvOf(
+		core.Secret{Path: "/secret/app/same", Value: "same"},
+		core.Secret{Path: "/secret/app/changed", Value: "new"},
+		core.Secret{Path: "/secret/app/added", Value: "added"},
+	)
+	cases := []struct {
+		prune    bool
+		expected []core.Secret
+	}{
+		{false, []core.Secret{
+			{Path: "/other/keep", Value: "EJ[kept]"},
+			{Path: "/secret/app/added", Value: "added"},
+			{Path: "/secret/app/changed", Value: "new"},
+			{Path: "/secret/app/missing", Value: "EJ[missing]"},
+			{Path: "/secret/app/same", Value: "EJ[same]"},
+		}},
+		{true, []core.Secret{
+			{Path: "/other/keep", Value: "EJ[kept]"},
+			{Path: "/secret/app/added", Value: "added"},
+			{Path: "/secret/app/changed", Value: "new"},
+			{Path: "/secret/app/same", Value: "EJ[same]"},
+		}},
+	}
+	for _, c := range cases {
+		c2 := &collector{}
+		WalkKV(MergeKV(existing, plain, walked, "/secret/app/", c.prune), c2)
+		if !reflect.DeepEqual(c2.secrets, c.expected) {
+			t.Fatalf("prune=%v: expected: %v but result was: %v\n", c.prune, c.expected, c2.secrets)
+		}
+	}
+}
+
+func TestJSONEndpoint_Merge(t *testing.T) {
+	j := NewJSONEndpoint()
+	j.Visit(core.Secret{Path: "/secret/foo", Value: "bar"})
+	existing := `{"secret": {"foo": {"child": "x"}}, "other": "y"}`
+	if err := j.Merge(strings.NewReader(existing), "/secret/", false); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	WalkKV(j.kv, c)
+	expected := []core.Secret{
+		{Path: "/other", Value: "y"},
+		{Path: "/secret/foo", Value: "bar"},
+		{Path: "/secret/foo/child", Value: "x"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestJSONEndpoint_MergeLayouts(t *testing.T) {
+	flat := `{"syncrets":{"version":1,"layout":"flat"},"secrets":{"/other/db":{"user":"admin","password":"hunter2"}}}`
+	j := NewJSONEndpoint()
+	j.Visit(core.Secret{Path: "/secret/foo", Value: "bar"})
+	if err := j.Merge(strings.NewReader(flat), "/secret/", false); err == nil {
+		t.Fatal("Expected a nested merge into a flat document to be refused")
+	}
+	j.Layout = JSONLayoutFlat
+	if err := j.Merge(strings.NewReader(flat), "/secret/", false); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	j.Walk(c)
+	expected := []core.Secret{
+		{Path: "/other/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+		{Path: "/secret/foo", Value: "bar"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestJSONEndpoint_MergeKeepsTypes(t *testing.T) {
+	j := NewJSONEndpoint()
+	j.Visit(core.Secret{Path: "/secret/app/db", Value: "hunter2"})
+	existing := `{"other":{"empty":{},"flag":true,"none":null,"port":5432,"ratio":1.50,"tags":["a","b"]},"secret":{"app":{"db":"old"}}}`
+	if err := j.Merge(strings.NewReader(existing), "/secret/app/", false); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err := j.Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"syncrets":{"version":1,"layout":"nested"},"secrets":{"other":{"empty":{},"flag":true,"none":null,"port":5432,"ratio":1.50,"tags":["a","b"]},"secret":{"app":{"db":"hunter2"}}}}` + "\n"
+	if out.String() != expected {
+		t.Fatalf("Expected the keys outside the prefix to be kept as they were:\n%s\nbut result was:\n%s\n", expected, out)
+	}
+}
@@ -1,7 +1,9 @@
 package backend
 
 import (
+	"errors"
 	"log"
+	"strings"
 
 	vaultapi "github.com/hashicorp/vault/api"
 )
@@ -10,9 +12,37 @@ type mockVaultClient struct {
 	valid bool
 	token string
 	data  map[string]map[string]interface{}
+	// lists holds the keys listed at a path, separately from the data read there
+	lists map[string][]interface{}
+	// errors fails the reads and lists of a path
+	errors map[string]error
+	reads  []string
+	writes map[string]map[string]interface{}
+	// mounts holds the KV version of the mounts by their path, e.g. "secret/"
+	mounts map[string]string
 }
 
 func (v *mockVaultClient) Read(path string) (*vaultapi.Secret, error) {
+	v.reads = append(v.reads, path)
+	if err := v.errors[path]; err != nil {
+		return nil, err
+	}
+	if mountPath := strings.TrimPrefix(path, "sys/internal/ui/mounts/"); mountPath != path {
+		for mount, version := range v.mounts {
+			if strings.HasPrefix(mountPath+"/", mount) {
+				return &vaultapi.Secret{Data: map[string]interface{}{
+					"path":    mount,
+					"type":    "kv",
+					"options": map[string]interface{}{"version": version},
+				}}, nil
+			}
+		}
+		return nil, errors.New("preflight capability check returned 403")
+	}
+	if _, ok := v.data[path]; !ok {
+		// like vault, a missing secret is not an error
+		return nil, nil
+	}
 	s := &vaultapi.Secret{}
 	s.Data = v.data[path] //make(map[string]interface{})
 	log.Printf("mock vault data: %v", v.data)
@@ -21,12 +51,25 @@ func (v *mockVaultClient) Read(path string) (*vaultapi.Secret, error) {
 }
 
 func (v *mockVaultClient) List(path string) (*vaultapi.Secret, error) {
+	if err := v.errors[path]; err != nil {
+		return nil, err
+	}
 	s := &vaultapi.Secret{}
+	if v.lists != nil {
+		if keys, ok := v.lists[path]; ok {
+			s.Data = map[string]interface{}{"keys": keys}
+		}
+		return s, nil
+	}
 	s.Data = v.data[path] //make(map[string]interface{})
 	return s, nil
 }
 
 func (v *mockVaultClient) Write(path string, data map[string]interface{}) (*vaultapi.Secret, error) {
+	if v.writes == nil {
+		v.writes = make(map[string]map[string]interface{})
+	}
+	v.writes[path] = data
 	s := &vaultapi.Secret{}
 	s.Data = v.data[path] //make(map[string]interface{})
 	return s, nil
@@ -0,0 +1,22 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// example request from the AWS signature version 4 documentation
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, nil, creds, "us-east-1", "iam", now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, auth)
+	}
+}
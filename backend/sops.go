@@ -0,0 +1,558 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/openpgp"
+	pgparmor "golang.org/x/crypto/openpgp/armor"
+	// openpgp falls back to RIPEMD160 for keys without hash preferences
+	_ "golang.org/x/crypto/ripemd160"
+	"gopkg.in/yaml.v3"
+)
+
+// SOPSScheme selects a SOPS encrypted file, e.g. sops://secrets.yaml or sops://secrets.json
+const SOPSScheme = "sops://"
+
+const (
+	sopsVersion           = "3.7.3"
+	sopsUnencryptedSuffix = "_unencrypted"
+	sopsNonceSize         = 32
+)
+
+var sopsEncrypted = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+type sopsAgeEntry struct {
+	Recipient string `yaml:"recipient" json:"recipient"`
+	Enc       string `yaml:"enc" json:"enc"`
+}
+
+type sopsPGPEntry struct {
+	CreatedAt   string `yaml:"created_at" json:"created_at"`
+	Enc         string `yaml:"enc" json:"enc"`
+	Fingerprint string `yaml:"fp" json:"fp"`
+}
+
+// sopsMetadata is the sops section of a SOPS file
+type sopsMetadata struct {
+	Age               []sopsAgeEntry `yaml:"age,omitempty" json:"age,omitempty"`
+	LastModified      string         `yaml:"lastmodified" json:"lastmodified"`
+	MAC               string         `yaml:"mac" json:"mac"`
+	PGP               []sopsPGPEntry `yaml:"pgp,omitempty" json:"pgp,omitempty"`
+	UnencryptedSuffix string         `yaml:"unencrypted_suffix,omitempty" json:"unencrypted_suffix,omitempty"`
+	Version           string         `yaml:"version" json:"version"`
+}
+
+// sopsItem is a key of a SOPS document, values are strings, bools or []sopsItem
+type sopsItem struct {
+	key   string
+	value interface{}
+}
+
+// SOPSKeys are the age and PGP keys used to encrypt and decrypt the data key
+type SOPSKeys struct {
+	Age        *AgeKeys
+	PGPPublic  openpgp.EntityList
+	PGPPrivate openpgp.EntityList
+}
+
+// NewSOPSKeys loads the age keys and the armored pgp.public_keys and
+// pgp.private_keys keyring files configured in syncrets.yml. As with sops,
+// age identities are also read from SOPS_AGE_KEY_FILE.
+func NewSOPSKeys(v *viper.Viper) (*SOPSKeys, error) {
+	ageKeys, err := NewAgeKeys(v)
+	if err != nil {
+		return nil, err
+	}
+	if file := os.Getenv("SOPS_AGE_KEY_FILE"); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity file '%s': %v", file, err)
+		}
+		ageKeys.Identities = append(ageKeys.Identities, ids...)
+	}
+	keys := &SOPSKeys{Age: ageKeys}
+	if keys.PGPPublic, err = readKeyRings(v.GetStringSlice("pgp.public_keys")); err != nil {
+		return nil, err
+	}
+	if keys.PGPPrivate, err = readKeyRings(v.GetStringSlice("pgp.private_keys")); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func readKeyRings(files []string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid pgp key file '%s': %v", file, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+	return keyring, nil
+}
+
+// encryptDataKey encrypts the data key separately for every age recipient and pgp key
+func (k *SOPSKeys) encryptDataKey(dataKey []byte, now time.Time) (*sopsMetadata, error) {
+	meta := &sopsMetadata{}
+	for _, r := range k.Age.Recipients {
+		buf := new(bytes.Buffer)
+		single := &AgeKeys{Recipients: []age.Recipient{r}}
+		if err := single.encrypt(buf, dataKey, true); err != nil {
+			return nil, err
+		}
+		meta.Age = append(meta.Age, sopsAgeEntry{Recipient: fmt.Sprintf("%v", r), Enc: buf.String()})
+	}
+	for _, e := range k.PGPPublic {
+		buf := new(bytes.Buffer)
+		armored, err := pgparmor.Encode(buf, "PGP MESSAGE", nil)
+		if err != nil {
+			return nil, err
+		}
+		w, err := openpgp.Encrypt(armored, []*openpgp.Entity{e}, nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(dataKey)
+		w.Close()
+		armored.Close()
+		meta.PGP = append(meta.PGP, sopsPGPEntry{
+			CreatedAt:   now.Format(time.RFC3339),
+			Enc:         buf.String() + "\n",
+			Fingerprint: fmt.Sprintf("%X", e.PrimaryKey.Fingerprint),
+		})
+	}
+	if len(meta.Age) == 0 && len(meta.PGP) == 0 {
+		return nil, errors.New("no age recipients or pgp public keys configured")
+	}
+	return meta, nil
+}
+
+// decryptDataKey tries the age identities and pgp private keys on each entry
+func (k *SOPSKeys) decryptDataKey(meta *sopsMetadata) ([]byte, error) {
+	for _, entry := range meta.Age {
+		if len(k.Age.Identities) == 0 {
+			break
+		}
+		dataKey, err := k.Age.decrypt(strings.NewReader(entry.Enc))
+		if err == nil {
+			return dataKey, nil
+		}
+		log.Printf("Cannot decrypt data key for age recipient %s: %v\n", entry.Recipient, err)
+	}
+	for _, entry := range meta.PGP {
+		if len(k.PGPPrivate) == 0 {
+			break
+		}
+		block, err := pgparmor.Decode(strings.NewReader(entry.Enc))
+		if err == nil {
+			var md *openpgp.MessageDetails
+			if md, err = openpgp.ReadMessage(block.Body, k.PGPPrivate, nil, nil); err == nil {
+				return ioutil.ReadAll(md.UnverifiedBody)
+			}
+		}
+		log.Printf("Cannot decrypt data key for pgp key %s: %v\n", entry.Fingerprint, err)
+	}
+	return nil, errors.New("cannot decrypt the sops data key with any of the configured age or pgp keys")
+}
+
+// sopsEncrypt encrypts a value with AES-GCM as sops does, the path of the value is the additional data
+func sopsEncrypt(value string, key []byte, aad string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, sopsNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nil, iv, []byte(value), []byte(aad))
+	tag := len(out) - aes.BlockSize
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(out[:tag]),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(out[tag:])), nil
+}
+
+// sopsDecrypt returns the plaintext and sops type of an encrypted value
+func sopsDecrypt(value string, key []byte, aad string) (string, string, error) {
+	m := sopsEncrypted.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", fmt.Errorf("value is not a sops encrypted value")
+	}
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return "", "", err
+	}
+	iv, err := base64.StdEncoding.DecodeString(m[2])
+	if err != nil {
+		return "", "", err
+	}
+	tag, err := base64.StdEncoding.DecodeString(m[3])
+	if err != nil {
+		return "", "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return "", "", err
+	}
+	return string(plain), m[4], nil
+}
+
+// sopsMACBytes returns the bytes sops hashes into the MAC for a value of a type
+func sopsMACBytes(value string, valueType string) []byte {
+	if valueType == "bool" {
+		// sops hashes booleans as True and False
+		return []byte(strings.Title(value))
+	}
+	return []byte(value)
+}
+
+// SOPSEndpoint reads and writes the nested JSON structure as a SOPS file
+type SOPSEndpoint struct {
+	kv     map[string]interface{}
+	keys   *SOPSKeys
+	isJSON bool
+	fields nestedFields
+}
+
+// NewSOPSEndpoint returns a SOPS endpoint for a YAML (or JSON if isJSON) file
+func NewSOPSEndpoint(keys *SOPSKeys, isJSON bool) *SOPSEndpoint {
+	return &SOPSEndpoint{kv: make(map[string]interface{}), keys: keys, isJSON: isJSON}
+}
+
+// ParseSOPSURL returns the file of a sops:// URL and whether it is a JSON file
+func ParseSOPSURL(raw string) (string, bool) {
+	file := strings.TrimPrefix(raw, SOPSScheme)
+	return file, strings.HasSuffix(file, ".json")
+}
+
+// Visit ...
+func (s *SOPSEndpoint) Visit(secret core.Secret) {
+	AddSecretToKV(secret, s.kv)
+	s.fields.add(secret)
+}
+
+// Walk the secrets read by Unmarshal
+func (s *SOPSEndpoint) Walk(visitor core.Visitor) {
+	WalkKV(s.kv, visitor)
+}
+
+// sopsItems converts a kv map into items with sorted keys
+func sopsItems(kv map[string]interface{}) []sopsItem {
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := make([]sopsItem, 0, len(keys))
+	for _, key := range keys {
+		if m, ok := kv[key].(map[string]interface{}); ok {
+			items = append(items, sopsItem{key, sopsItems(m)})
+		} else {
+			items = append(items, sopsItem{key, kv[key]})
+		}
+	}
+	return items
+}
+
+// encryptItems encrypts the values in place in document order, hashing the plaintext into mac.
+// A key ending with the unencrypted suffix leaves its whole subtree in plaintext.
+func encryptItems(items []sopsItem, path []string, key []byte, mac io.Writer, unencrypted bool) error {
+	for i := range items {
+		itemPath := append(append([]string{}, path...), items[i].key)
+		plaintext := unencrypted || strings.HasSuffix(items[i].key, sopsUnencryptedSuffix)
+		switch value := items[i].value.(type) {
+		case []sopsItem:
+			if err := encryptItems(value, itemPath, key, mac, plaintext); err != nil {
+				return err
+			}
+		default:
+			plain := fmt.Sprintf("%v", value)
+			mac.Write([]byte(plain))
+			if plaintext {
+				items[i].value = plain
+				continue
+			}
+			enc, err := sopsEncrypt(plain, key, strings.Join(itemPath, ":")+":")
+			if err != nil {
+				return err
+			}
+			items[i].value = enc
+		}
+	}
+	return nil
+}
+
+// decryptItems decrypts the values into kv in document order, hashing the plaintext into mac
+func decryptItems(items []sopsItem, path []string, key []byte, mac io.Writer, kv map[string]interface{}) error {
+	for _, item := range items {
+		itemPath := append(append([]string{}, path...), item.key)
+		switch value := item.value.(type) {
+		case []sopsItem:
+			m := make(map[string]interface{})
+			if err := decryptItems(value, itemPath, key, mac, m); err != nil {
+				return err
+			}
+			kv[item.key] = m
+		case bool:
+			mac.Write(sopsMACBytes(fmt.Sprintf("%v", value), "bool"))
+			kv[item.key] = fmt.Sprintf("%v", value)
+		case string:
+			if !sopsEncrypted.MatchString(value) {
+				// unencrypted values are still part of the MAC
+				mac.Write([]byte(value))
+				kv[item.key] = value
+				continue
+			}
+			plain, valueType, err := sopsDecrypt(value, key, strings.Join(itemPath, ":")+":")
+			if err != nil {
+				return fmt.Errorf("cannot decrypt %s: %v", strings.Join(itemPath, "/"), err)
+			}
+			mac.Write(sopsMACBytes(plain, valueType))
+			kv[item.key] = plain
+		}
+	}
+	return nil
+}
+
+// Marshal encrypts the secrets with a new data key and writes the SOPS file
+func (s *SOPSEndpoint) Marshal(out io.Writer) error {
+	if err := s.fields.err(); err != nil {
+		return err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	meta, err := s.keys.encryptDataKey(dataKey, now)
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	items := sopsItems(s.kv)
+	hash := sha512.New()
+	if err := encryptItems(items, nil, dataKey, hash, false); err != nil {
+		return err
+	}
+	meta.LastModified = now.Format(time.RFC3339)
+	meta.MAC, err = sopsEncrypt(fmt.Sprintf("%X", hash.Sum(nil)), dataKey, meta.LastModified)
+	if err != nil {
+		return err
+	}
+	meta.UnencryptedSuffix = sopsUnencryptedSuffix
+	meta.Version = sopsVersion
+	if s.isJSON {
+		return writeSOPSJSON(out, items, meta)
+	}
+	return writeSOPSYAML(out, items, meta)
+}
+
+func sopsYAMLNode(items []sopsItem) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, item := range items {
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item.key}
+		var valueNode *yaml.Node
+		if children, ok := item.value.([]sopsItem); ok {
+			valueNode = sopsYAMLNode(children)
+		} else {
+			valueNode = yamlNode(fmt.Sprintf("%v", item.value))
+		}
+		node.Content = append(node.Content, keyNode, valueNode)
+	}
+	return node
+}
+
+func writeSOPSYAML(out io.Writer, items []sopsItem, meta *sopsMetadata) error {
+	doc := sopsYAMLNode(items)
+	metaNode := &yaml.Node{}
+	if err := metaNode.Encode(meta); err != nil {
+		return err
+	}
+	doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "sops"}, metaNode)
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(4)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func appendSOPSJSON(buf *bytes.Buffer, items []sopsItem) error {
+	buf.WriteString("{")
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(item.key)
+		buf.Write(key)
+		buf.WriteString(":")
+		if children, ok := item.value.([]sopsItem); ok {
+			if err := appendSOPSJSON(buf, children); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := json.Marshal(fmt.Sprintf("%v", item.value))
+		if err != nil {
+			return err
+		}
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return nil
+}
+
+func writeSOPSJSON(out io.Writer, items []sopsItem, meta *sopsMetadata) error {
+	buf := new(bytes.Buffer)
+	if err := appendSOPSJSON(buf, items); err != nil {
+		return err
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// splice the sops metadata in as the last key of the document
+	buf.Truncate(buf.Len() - 1)
+	if len(items) > 0 {
+		buf.WriteString(",")
+	}
+	buf.WriteString(`"sops":`)
+	buf.Write(metaJSON)
+	buf.WriteString("}")
+	indented := new(bytes.Buffer)
+	if err := json.Indent(indented, buf.Bytes(), "", "\t"); err != nil {
+		return err
+	}
+	indented.WriteString("\n")
+	_, err = indented.WriteTo(out)
+	return err
+}
+
+// sopsItemsFromNode reads a YAML (or JSON) mapping into items in document order
+func sopsItemsFromNode(node *yaml.Node) ([]sopsItem, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping", node.Line)
+	}
+	var items []sopsItem
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch value.Kind {
+		case yaml.MappingNode:
+			children, err := sopsItemsFromNode(value)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, sopsItem{key, children})
+		case yaml.ScalarNode:
+			if value.Tag == "!!bool" {
+				items = append(items, sopsItem{key, value.Value == "true"})
+			} else {
+				items = append(items, sopsItem{key, value.Value})
+			}
+		default:
+			return nil, fmt.Errorf("line %d: lists are not supported", value.Line)
+		}
+	}
+	return items, nil
+}
+
+// Unmarshal decrypts a SOPS file, verifying its MAC
+func (s *SOPSEndpoint) Unmarshal(in io.Reader) error {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Sops *sopsMetadata `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if doc.Sops == nil {
+		return errors.New("not a sops file, the sops metadata is missing")
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return err
+	}
+	if len(root.Content) == 0 {
+		return errors.New("empty sops file")
+	}
+	// the sops metadata is not part of the data or the MAC
+	mapping := *root.Content[0]
+	mapping.Content = nil
+	for i := 0; i+1 < len(root.Content[0].Content); i += 2 {
+		if key := root.Content[0].Content[i]; key.Value != "sops" {
+			mapping.Content = append(mapping.Content, key, root.Content[0].Content[i+1])
+		}
+	}
+	data, err := sopsItemsFromNode(&mapping)
+	if err != nil {
+		return err
+	}
+	dataKey, err := s.keys.decryptDataKey(doc.Sops)
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	kv := make(map[string]interface{})
+	hash := sha512.New()
+	if err := decryptItems(data, nil, dataKey, hash, kv); err != nil {
+		return err
+	}
+	mac, _, err := sopsDecrypt(doc.Sops.MAC, dataKey, doc.Sops.LastModified)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt the sops MAC: %v", err)
+	}
+	if mac != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return errors.New("sops MAC mismatch, the file has been modified")
+	}
+	s.kv = kv
+	return nil
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/openpgp"
+	pgparmor "golang.org/x/crypto/openpgp/armor"
+)
+
+var sopsSecrets = []core.Secret{
+	{Path: "/secret/config_unencrypted/db/host", Value: "db.internal"},
+	{Path: "/secret/foo", Value: "bar"},
+	{Path: "/secret/foo/bar", Value: "foobar"},
+	{Path: "/secret/token_unencrypted", Value: "visible"},
+}
+
+func sopsRoundTrip(t *testing.T, keys *SOPSKeys, isJSON bool) string {
+	out := NewSOPSEndpoint(keys, isJSON)
+	for _, s := range sopsSecrets {
+		out.Visit(s)
+	}
+	buf := new(bytes.Buffer)
+	if err := out.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	in := NewSOPSEndpoint(keys, isJSON)
+	if err := in.Unmarshal(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	c := &collector{}
+	in.Walk(c)
+	if !reflect.DeepEqual(c.secrets, sopsSecrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", sopsSecrets, c.secrets)
+	}
+	return buf.String()
+}
+
+func TestSOPS_AgeRoundTrip(t *testing.T) {
+	v, identityFile := setupAgeViper(t, 2)
+	defer os.Remove(identityFile)
+	keys, err := NewSOPSKeys(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, isJSON := range []bool{false, true} {
+		doc := sopsRoundTrip(t, keys, isJSON)
+		if strings.Contains(doc, "foobar") {
+			t.Fatalf("Secret value is not encrypted:\n%s", doc)
+		}
+		for _, expected := range []string{"ENC[AES256_GCM,data:", "visible", "db.internal", "recipient", "lastmodified", "unencrypted_suffix"} {
+			if !strings.Contains(doc, expected) {
+				t.Fatalf("Expected %s in:\n%s", expected, doc)
+			}
+		}
+		if strings.Count(doc, "BEGIN AGE ENCRYPTED FILE") != 2 {
+			t.Fatalf("Expected the data key encrypted for each recipient:\n%s", doc)
+		}
+	}
+}
+
+func TestSOPS_MACMismatch(t *testing.T) {
+	v, identityFile := setupAgeViper(t, 1)
+	defer os.Remove(identityFile)
+	keys, err := NewSOPSKeys(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := sopsRoundTrip(t, keys, false)
+	tampered := strings.Replace(doc, "visible", "changed", 1)
+	in := NewSOPSEndpoint(keys, false)
+	if err := in.Unmarshal(strings.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+		t.Fatalf("Expected a MAC mismatch but got: %v\n", err)
+	}
+}
+
+func writeKeyRing(t *testing.T, e *openpgp.Entity, private bool) string {
+	f, err := ioutil.TempFile("", "syncrets-pgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+	w, err := pgparmor.Encode(f, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if private {
+		err = e.SerializePrivate(w, nil)
+	} else {
+		err = e.Serialize(w)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return f.Name()
+}
+
+func TestSOPS_PGPRoundTrip(t *testing.T) {
+	e, err := openpgp.NewEntity("syncrets test", "", "syncrets@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := writeKeyRing(t, e, false)
+	defer os.Remove(public)
+	private := writeKeyRing(t, e, true)
+	defer os.Remove(private)
+	v := viper.New()
+	v.Set("pgp.public_keys", []string{public})
+	v.Set("pgp.private_keys", []string{private})
+	keys, err := NewSOPSKeys(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := sopsRoundTrip(t, keys, false)
+	if !strings.Contains(doc, "BEGIN PGP MESSAGE") || !strings.Contains(doc, "fp: ") {
+		t.Fatalf("Expected a pgp entry in:\n%s", doc)
+	}
+}
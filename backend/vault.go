@@ -7,8 +7,10 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/drmdrew/syncrets/core"
 	"github.com/spf13/viper"
@@ -27,6 +29,13 @@ type Vault struct {
 	viper   *viper.Viper
 	client  VaultAPI
 	isValid *bool
+	mounts  []kvMount
+}
+
+// kvMount is a mount of the KV secrets engine and its version
+type kvMount struct {
+	path    string
+	version int
 }
 
 // SecretsReader is just the Read portion of the Vault client API
@@ -129,6 +138,11 @@ func (v *Vault) GetName() string {
 	return v.name
 }
 
+// GetSection returns the section of the config holding the vault aliases
+func (v *Vault) GetSection() string {
+	return "vault"
+}
+
 // GetPath ...
 func (v *Vault) GetPath() string {
 	return v.path
@@ -151,6 +165,11 @@ func (v *Vault) GetRawURL() *url.URL {
 
 // prompt the user for information
 func (v *Vault) prompt(prompt string) (string, error) {
+	return promptSecret(prompt)
+}
+
+// promptSecret prompts the user for information without echoing it
+func promptSecret(prompt string) (string, error) {
 	log.Printf("Prompting for user input: %s\n", prompt)
 	fmt.Printf(prompt)
 	bytes, err := terminal.ReadPassword(int(syscall.Stdin))
@@ -278,8 +297,10 @@ func (v *Vault) Store() {
 
 // Write ...
 func (src *Vault) Write(secret core.Secret) error {
-	data := map[string]interface{}{
-		"value": secret.Value,
+	data := secret.Data()
+	if _, isKV2 := src.kvMetadataPath(secret.Path); isKV2 {
+		// KV version 2 takes the fields wrapped in data
+		data = map[string]interface{}{"data": data}
 	}
 	_, err := src.GetClient().Write(secret.Path, data)
 	return err
@@ -301,9 +322,10 @@ func (src *Vault) Walk(visitor core.Visitor) {
 		// pop a prefix from the front of the slice
 		var prefix string
 		prefix, prefixes = prefixes[0], prefixes[1:]
-		secret, err := src.GetClient().List(prefix)
+		secret, err := src.GetClient().List(src.listPath(prefix))
 		if err != nil {
 			log.Printf("   -> list error: %v\n", err)
+			core.ReportError(visitor, prefix, err)
 			continue
 		}
 		log.Printf("   -> list prefix %v: %v\n", prefix, secret != nil)
@@ -322,16 +344,20 @@ func (src *Vault) Walk(visitor core.Visitor) {
 					}
 					path := fmt.Sprintf("%s%s%s", prefix, sep, s)
 					//fmt.Printf("%s\n", path)
+					if src.unchanged(visitor, path) {
+						log.Printf("       <- unchanged path=%s\n", path)
+						continue
+					}
 					// ... so copy it to dst vault
 					value, err := src.GetClient().Read(path)
-					if value != nil {
-						data := value.Data
-						//bValue, bErr := dst.Vault.GetClient().Write(path, data)
-						secret := core.Secret{path, data["value"].(string)}
-						visitor.Visit(secret)
-						log.Printf("       <- visited path=%s, err=%v\n", path, err)
-					} else {
-						fmt.Printf("       !! err: %v\n", err)
+					if err != nil {
+						log.Printf("       !! err: %v\n", err)
+						core.ReportError(visitor, path, err)
+					} else if value != nil {
+						if secret, ok := src.newVaultSecret(path, value.Data); ok {
+							visitor.Visit(secret)
+							log.Printf("       <- visited path=%s\n", path)
+						}
 					}
 				}
 			}
@@ -340,6 +366,7 @@ func (src *Vault) Walk(visitor core.Visitor) {
 		leafSecret, leafErr := src.readSecret(prefix)
 		if leafErr != nil {
 			log.Printf("   -> readSecret prefix %v error: %v\n", prefix, leafErr)
+			core.ReportError(visitor, prefix, leafErr)
 			continue
 		}
 		if leafSecret != nil {
@@ -349,16 +376,145 @@ func (src *Vault) Walk(visitor core.Visitor) {
 	}
 }
 
+// Read the secret at path, nil if there is none or its version was deleted
+func (v *Vault) Read(path string) (*core.Secret, error) {
+	return v.readSecret(path)
+}
+
 func (v *Vault) readSecret(path string) (*core.Secret, error) {
 	value, err := v.GetClient().Read(path)
 	var secret *core.Secret
 	if err == nil && value != nil {
-		data := value.Data
-		secret = &core.Secret{path, data["value"].(string)}
+		if s, ok := v.newVaultSecret(path, value.Data); ok {
+			secret = &s
+		}
 	}
 	return secret, err
 }
 
+// kvMount returns the KV mount holding path. Its version is read from the
+// kv_version option of the endpoint, or else asked to vault like its CLI does.
+// Mounts that cannot be asked about are taken as KV version 1.
+func (v *Vault) kvMount(path string) kvMount {
+	path = strings.TrimPrefix(path, "/")
+	for _, m := range v.mounts {
+		if strings.HasPrefix(path+"/", m.path) {
+			return m
+		}
+	}
+	m := kvMount{path: strings.SplitN(path, "/", 2)[0] + "/", version: 1}
+	if version := v.viper.GetInt(fmt.Sprintf("vault.%s.kv_version", v.name)); version != 0 {
+		m.version = version
+	} else if mount, err := v.GetClient().Read("sys/internal/ui/mounts/" + path); err != nil || mount == nil {
+		log.Printf("Cannot read the mount of %s, assuming KV version 1: %v\n", path, err)
+	} else {
+		if p, ok := mount.Data["path"].(string); ok && p != "" {
+			m.path = strings.TrimPrefix(p, "/")
+		}
+		if options, ok := mount.Data["options"].(map[string]interface{}); ok && fmt.Sprintf("%v", options["version"]) == "2" {
+			m.version = 2
+		}
+	}
+	log.Printf("%s is mounted at %s with KV version %d\n", path, m.path, m.version)
+	v.mounts = append(v.mounts, m)
+	return m
+}
+
+// kvMetadataPath returns the metadata path for a data path of a KV version 2
+// mount, false for the paths of other mounts
+func (v *Vault) kvMetadataPath(path string) (string, bool) {
+	m := v.kvMount(path)
+	if m.version != 2 {
+		return "", false
+	}
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, "/"), m.path)
+	if rest != "data" && !strings.HasPrefix(rest, "data/") {
+		return "", false
+	}
+	return m.path + "metadata/" + strings.TrimPrefix(strings.TrimPrefix(rest, "data"), "/"), true
+}
+
+// listPath returns the path listing the keys under a prefix, KV version 2
+// mounts only list under metadata/ while the secrets are read under data/
+func (v *Vault) listPath(prefix string) string {
+	if path, ok := v.kvMetadataPath(prefix); ok {
+		return path
+	}
+	return prefix
+}
+
+// unchanged reads the current version of a KV version 2 secret from its
+// metadata and reports whether the visitor already holds that version
+func (src *Vault) unchanged(visitor core.Visitor, path string) bool {
+	skipper, ok := visitor.(core.VersionSkipper)
+	if !ok {
+		return false
+	}
+	metadataPath, ok := src.kvMetadataPath(path)
+	if !ok {
+		return false
+	}
+	metadata, err := src.GetClient().Read(metadataPath)
+	if err != nil || metadata == nil {
+		return false
+	}
+	version, err := strconv.Atoi(fmt.Sprintf("%v", metadata.Data["current_version"]))
+	if err != nil {
+		return false
+	}
+	versions, _ := metadata.Data["versions"].(map[string]interface{})
+	if current, ok := versions[strconv.Itoa(version)].(map[string]interface{}); ok {
+		if deleted, _ := current["deletion_time"].(string); deleted != "" || current["destroyed"] == true {
+			// read it, so that the deletion is noticed
+			return false
+		}
+	}
+	return skipper.Unchanged(path, version)
+}
+
+// Renew the token, authenticating again if it is no longer valid
+func (v *Vault) Renew() error {
+	v.isValid = nil
+	if !v.IsValid() {
+		v.isValid = nil
+		if err := v.Authenticate(); err != nil {
+			return err
+		}
+		if !v.IsValid() {
+			return errors.New("vault authentication failed")
+		}
+		v.Store()
+		return nil
+	}
+	if _, err := v.GetClient().Write("auth/token/renew-self", nil); err != nil {
+		// tokens without a TTL cannot be renewed but remain valid
+		log.Printf("Cannot renew token of %s: %v\n", v.name, err)
+	}
+	return nil
+}
+
+// newVaultSecret returns the secret read at path, unwrapping the fields and
+// metadata of KV version 2 responses. It returns false for a deleted version.
+func (v *Vault) newVaultSecret(path string, data map[string]interface{}) (core.Secret, bool) {
+	if _, isKV2 := v.kvMetadataPath(path); !isKV2 {
+		return core.NewSecret(path, data), true
+	}
+	fields, _ := data["data"].(map[string]interface{})
+	if fields == nil {
+		// the current version was deleted or destroyed
+		return core.Secret{}, false
+	}
+	secret := core.NewSecret(path, fields)
+	metadata, _ := data["metadata"].(map[string]interface{})
+	if version, err := strconv.Atoi(fmt.Sprintf("%v", metadata["version"])); err == nil {
+		secret.Metadata.Version = version
+	}
+	if created, ok := metadata["created_time"].(string); ok {
+		secret.Metadata.Modified, _ = time.Parse(time.RFC3339Nano, created)
+	}
+	return secret, true
+}
+
 func (v *Vault) resolveArgs(args []string) error {
 	if len(args) < 1 {
 		return errors.New("source argument is missing")
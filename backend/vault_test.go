@@ -1,9 +1,15 @@
 package backend
 
 import (
+	"encoding/json"
+	"errors"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/drmdrew/syncrets/core"
 	"github.com/spf13/viper"
 )
 
@@ -39,3 +45,170 @@ func TestAuthenticate_withValidToken(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNewVaultSecret_KVv2(t *testing.T) {
+	v, mockVault := setupVault(t, map[string]map[string]interface{}{"auth/token/lookup-self": {"id": "mock-token"}})
+	mockVault.mounts = map[string]string{"secret/": "2", "kv/": "1"}
+	data := map[string]interface{}{
+		"data":     map[string]interface{}{"user": "admin"},
+		"metadata": map[string]interface{}{"version": json.Number("4"), "created_time": "2024-01-02T03:04:05.123456Z"},
+	}
+	s, _ := v.newVaultSecret("secret/data/app", data)
+	if s.Fields["user"] != "admin" || s.Metadata.Version != 4 || s.Metadata.Modified.Year() != 2024 {
+		t.Fatalf("Unexpected KV v2 secret: %+v\n", s)
+	}
+	s, _ = v.newVaultSecret("kv/app", map[string]interface{}{"value": "bar"})
+	if s.Value != "bar" || s.Metadata.Version != 0 {
+		t.Fatalf("Unexpected KV v1 secret: %+v\n", s)
+	}
+}
+
+func TestKVMetadataPath(t *testing.T) {
+	v, mockVault := setupVault(t, map[string]map[string]interface{}{"auth/token/lookup-self": {"id": "mock-token"}})
+	mockVault.mounts = map[string]string{"secret/": "2", "kv/": "1", "team/kv/": "2"}
+	if path, ok := v.kvMetadataPath("/secret/data/app/db"); !ok || path != "secret/metadata/app/db" {
+		t.Fatalf("Unexpected metadata path: %s %v\n", path, ok)
+	}
+	if path, ok := v.kvMetadataPath("/secret/data"); !ok || path != "secret/metadata/" {
+		t.Fatalf("Unexpected metadata path of the mount: %s %v\n", path, ok)
+	}
+	if path, ok := v.kvMetadataPath("/team/kv/data/app"); !ok || path != "team/kv/metadata/app" {
+		t.Fatalf("Unexpected metadata path of a nested mount: %s %v\n", path, ok)
+	}
+	if _, ok := v.kvMetadataPath("/kv/data/app/db"); ok {
+		t.Fatal("Expected no metadata path for a data folder of a KV version 1 mount")
+	}
+	if _, ok := v.kvMetadataPath("/unknown/data/app"); ok {
+		t.Fatal("Expected no metadata path for a mount that cannot be read")
+	}
+}
+
+func TestKVMetadataPath_KVVersionOption(t *testing.T) {
+	v, mockVault := setupVault(t, map[string]map[string]interface{}{"auth/token/lookup-self": {"id": "mock-token"}})
+	mockVault.mounts = map[string]string{"secret/": "2"}
+	v.viper.Set("vault.vault-a.kv_version", 1)
+	if _, ok := v.kvMetadataPath("/secret/data/app/db"); ok {
+		t.Fatal("Expected the kv_version option to override the mount version")
+	}
+	v.viper.Set("vault.vault-a.kv_version", 2)
+	v.mounts = nil
+	if path, ok := v.kvMetadataPath("/other/data/app"); !ok || path != "other/metadata/app" {
+		t.Fatalf("Unexpected metadata path: %s %v\n", path, ok)
+	}
+}
+
+func TestVaultWalk_ListError(t *testing.T) {
+	mockData := map[string]map[string]interface{}{
+		"auth/token/lookup-self": {"id": "mock-token"},
+		"/secret/a":              {"value": "1"},
+		"/secret/sub/b":          {"value": "2"},
+	}
+	v, mockVault := setupVault(t, mockData)
+	v.path = "/secret/"
+	mockVault.lists = map[string][]interface{}{"/secret/": {"a", "sub/"}}
+	mockVault.errors = map[string]error{"/secret/sub/": errors.New("permission denied")}
+	c := &collector{}
+	err := core.Walk(v, c)
+	if err == nil || !strings.Contains(err.Error(), "/secret/sub/: permission denied") {
+		t.Fatalf("Expected the list error to be reported: %v\n", err)
+	}
+	if len(c.secrets) != 1 || c.secrets[0].Path != "/secret/a" {
+		t.Fatalf("Unexpected secrets: %v\n", c.secrets)
+	}
+}
+
+// versionSkipper knows some versions of the secrets
+type versionSkipper struct {
+	collector
+	versions map[string]int
+}
+
+func (s *versionSkipper) Unchanged(path string, version int) bool {
+	return s.versions[path] == version
+}
+
+func TestVaultWalk_KVv2(t *testing.T) {
+	mockData := map[string]map[string]interface{}{
+		"auth/token/lookup-self": {"id": "mock-token"},
+		"/secret/data/app/db": {
+			"data":     map[string]interface{}{"password": "hunter2"},
+			"metadata": map[string]interface{}{"version": json.Number("3"), "created_time": "2024-01-02T03:04:05Z"},
+		},
+		"/secret/data/app/api": {
+			"data":     map[string]interface{}{"token": "t"},
+			"metadata": map[string]interface{}{"version": json.Number("1"), "created_time": "2024-01-01T00:00:00Z"},
+		},
+		"secret/metadata/app/db":  {"current_version": json.Number("3")},
+		"secret/metadata/app/api": {"current_version": json.Number("1")},
+	}
+	v, mockVault := setupVault(t, mockData)
+	mockVault.mounts = map[string]string{"secret/": "2"}
+	v.path = "/secret/data/app/"
+	mockVault.lists = map[string][]interface{}{"secret/metadata/app/": {"db", "api"}}
+
+	skipper := &versionSkipper{versions: map[string]int{"/secret/data/app/db": 3}}
+	mockVault.reads = nil
+	if err := core.Walk(v, skipper); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range mockVault.reads {
+		if path == "/secret/data/app/db" {
+			t.Fatalf("Expected the read of the known version to be skipped: %v\n", mockVault.reads)
+		}
+	}
+	if len(skipper.secrets) != 1 || skipper.secrets[0].Path != "/secret/data/app/api" {
+		t.Fatalf("Unexpected secrets: %v\n", skipper.secrets)
+	}
+}
+
+func TestVaultWalk_KVv2Metadata(t *testing.T) {
+	mockData := map[string]map[string]interface{}{
+		"auth/token/lookup-self": {"id": "mock-token"},
+		"/secret/data/app/db": {
+			"data":     map[string]interface{}{"password": "hunter2"},
+			"metadata": map[string]interface{}{"version": json.Number("3"), "created_time": "2024-01-02T03:04:05Z"},
+		},
+		"/secret/data/app/old": {
+			"data":     nil,
+			"metadata": map[string]interface{}{"version": json.Number("2"), "deletion_time": "2024-01-03T00:00:00Z"},
+		},
+		"secret/metadata/app/old": {
+			"current_version": json.Number("2"),
+			"versions":        map[string]interface{}{"2": map[string]interface{}{"deletion_time": "2024-01-03T00:00:00Z"}},
+		},
+	}
+	v, mockVault := setupVault(t, mockData)
+	mockVault.mounts = map[string]string{"secret/": "2"}
+	v.path = "/secret/data/app/"
+	mockVault.lists = map[string][]interface{}{"secret/metadata/app/": {"db", "old"}}
+
+	// the deleted version must be read even though its version is known
+	skipper := &versionSkipper{versions: map[string]int{"/secret/data/app/old": 2}}
+	if err := core.Walk(v, skipper); err != nil {
+		t.Fatal(err)
+	}
+	if len(skipper.secrets) != 1 {
+		t.Fatalf("Expected the deleted secret to be skipped: %v\n", skipper.secrets)
+	}
+	s := skipper.secrets[0]
+	if s.Path != "/secret/data/app/db" || s.Fields["password"] != "hunter2" || s.Metadata.Version != 3 {
+		t.Fatalf("Unexpected secret: %+v\n", s)
+	}
+	if expected := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !s.Metadata.Modified.Equal(expected) {
+		t.Fatalf("Expected the modified time %v but found %v\n", expected, s.Metadata.Modified)
+	}
+}
+
+func TestVaultWrite_KVv2(t *testing.T) {
+	v, mockVault := setupVault(t, map[string]map[string]interface{}{"auth/token/lookup-self": {"id": "mock-token"}})
+	mockVault.mounts = map[string]string{"secret/": "2", "kv/": "1"}
+	v.Write(core.Secret{Path: "/secret/data/app/db", Fields: map[string]string{"password": "hunter2"}})
+	v.Write(core.Secret{Path: "/kv/data/app/db", Value: "v1"})
+	expected := map[string]interface{}{"data": map[string]interface{}{"password": "hunter2"}}
+	if !reflect.DeepEqual(mockVault.writes["/secret/data/app/db"], expected) {
+		t.Fatalf("Expected the fields wrapped in data: %v\n", mockVault.writes)
+	}
+	if !reflect.DeepEqual(mockVault.writes["/kv/data/app/db"], map[string]interface{}{"value": "v1"}) {
+		t.Fatalf("Expected KV version 1 fields as they are: %v\n", mockVault.writes)
+	}
+}
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLEndpoint reads and writes secrets as a nested YAML document
+type YAMLEndpoint struct {
+	kv     map[string]interface{}
+	fields nestedFields
+}
+
+// NewYAMLEndpoint ...
+func NewYAMLEndpoint() *YAMLEndpoint {
+	return &YAMLEndpoint{kv: make(map[string]interface{})}
+}
+
+// Visit ...
+func (y *YAMLEndpoint) Visit(s core.Secret) {
+	AddSecretToKV(s, y.kv)
+	y.fields.add(s)
+}
+
+// Walk the secrets read by Unmarshal
+func (y *YAMLEndpoint) Walk(visitor core.Visitor) {
+	WalkKV(y.kv, visitor)
+}
+
+// Marshal writes the secrets with sorted keys, multiline values as block scalars
+func (y *YAMLEndpoint) Marshal(out io.Writer) error {
+	if err := y.fields.err(); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(yamlNode(y.kv)); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	return enc.Close()
+}
+
+// Unmarshal reads secrets from a YAML document
+func (y *YAMLEndpoint) Unmarshal(in io.Reader) error {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	kv := make(map[string]interface{})
+	if err := yaml.Unmarshal(b, &kv); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return err
+	}
+	y.kv = kv
+	return nil
+}
+
+func yamlNode(value interface{}) *yaml.Node {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+			node.Content = append(node.Content, keyNode, yamlNode(v[key]))
+		}
+		return node
+	case string:
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if strings.Contains(v, "\n") {
+			node.Style = yaml.LiteralStyle
+		}
+		return node
+	default:
+		node := &yaml.Node{}
+		node.Encode(v)
+		return node
+	}
+}
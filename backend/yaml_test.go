@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+var yamlTests = []struct {
+	secrets  []core.Secret
+	expected string
+}{
+	{[]core.Secret{{Path: "secret/citizen", Value: "four"}},
+		"secret:\n  citizen: four\n"},
+	{[]core.Secret{{Path: "secret/zebra", Value: "z"}, {Path: "secret/aardvark", Value: "a"}},
+		"secret:\n  aardvark: a\n  zebra: z\n"},
+	{[]core.Secret{{Path: "secret/citizen", Value: "four"}, {Path: "secret/citizen/kane", Value: "Rosebud"}},
+		"secret:\n  citizen:\n    .: four\n    kane: Rosebud\n"},
+	{[]core.Secret{{Path: "secret/flag", Value: "true"}},
+		"secret:\n  flag: \"true\"\n"},
+	{[]core.Secret{{Path: "secret/cert", Value: "-----BEGIN-----\nMIIB\n-----END-----\n"}},
+		"secret:\n  cert: |\n    -----BEGIN-----\n    MIIB\n    -----END-----\n"},
+}
+
+func TestYAML_Marshal(t *testing.T) {
+	for _, testcase := range yamlTests {
+		buf := new(bytes.Buffer)
+		y := NewYAMLEndpoint()
+		for _, s := range testcase.secrets {
+			y.Visit(s)
+		}
+		y.Marshal(buf)
+		if buf.String() != testcase.expected {
+			t.Fatalf("Expected: '%s' but result was: '%s'\n", testcase.expected, buf.String())
+		}
+	}
+}
+
+type collector struct {
+	secrets []core.Secret
+}
+
+func (c *collector) Visit(s core.Secret) {
+	c.secrets = append(c.secrets, s)
+}
+
+func TestNestedExports_Fields(t *testing.T) {
+	fields := core.Secret{Path: "/secret/app/db", Fields: map[string]string{"user": "admin"}}
+	y := NewYAMLEndpoint()
+	y.Visit(fields)
+	if err := y.Marshal(new(bytes.Buffer)); err == nil || !strings.Contains(err.Error(), "/secret/app/db") {
+		t.Fatalf("Expected YAML to refuse the fields of /secret/app/db: %v\n", err)
+	}
+	j := NewJSONEndpoint()
+	j.Visit(fields)
+	if err := j.Marshal(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected nested JSON to refuse fields")
+	}
+	j.Layout = JSONLayoutFlat
+	if err := j.Marshal(new(bytes.Buffer)); err != nil {
+		t.Fatalf("Expected flat JSON to hold fields: %v\n", err)
+	}
+	e := NewEJSONEndpoint("key")
+	e.Visit(fields)
+	if err := e.Marshal(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected ejson to refuse fields")
+	}
+}
+
+func TestYAML_RoundTrip(t *testing.T) {
+	secrets := []core.Secret{
+		{Path: "/secret/cert", Value: "line one\nline two\n"},
+		{Path: "/secret/foo", Value: "bar"},
+		{Path: "/secret/foo/bar", Value: "foobar"},
+		{Path: "/secret/it/was/the/best/of/times", Value: "it was the worst of times"},
+	}
+	buf := new(bytes.Buffer)
+	out := NewYAMLEndpoint()
+	for _, s := range secrets {
+		out.Visit(s)
+	}
+	if err := out.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	in := NewYAMLEndpoint()
+	if err := in.Unmarshal(strings.NewReader(buf.String())); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	in.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+}
@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+)
+
+// --prefer choices for paths changed on both sides
+const (
+	preferNone        = ""
+	preferSource      = "source"
+	preferDestination = "destination"
+	preferNewer       = "newer"
+)
+
+// StateKeyEnv holds the HMAC key of the fingerprints in the state file,
+// the key is kept next to the state file if it is not set
+const StateKeyEnv = "SYNCRETS_STATE_KEY"
+
+// syncStateVersion is the version of the state file format, version 1
+// held unkeyed hashes that cannot be compared with the HMACs of version 2
+const syncStateVersion = 2
+
+// syncState is the fingerprint of every path after the last bidirectional sync
+type syncState struct {
+	Version     int               `json:"version"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination"`
+	Paths       map[string]string `json:"paths"`
+}
+
+// loadSyncState reads a state file, a missing file is an empty state
+func loadSyncState(file string) (*syncState, error) {
+	state := &syncState{Version: syncStateVersion, Paths: make(map[string]string)}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", file, err)
+	}
+	if state.Version != syncStateVersion {
+		return nil, fmt.Errorf("unsupported state file version %d in %s, remove it to start over", state.Version, file)
+	}
+	if state.Paths == nil {
+		state.Paths = make(map[string]string)
+	}
+	return state, nil
+}
+
+// stateKey returns the HMAC key of a state file from $SYNCRETS_STATE_KEY,
+// or from the .key file next to it, which is created on the first run
+func stateKey(file string) ([]byte, error) {
+	if key := os.Getenv(StateKeyEnv); key != "" {
+		return []byte(key), nil
+	}
+	keyFile := file + ".key"
+	b, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		err = backend.WriteFileAtomic(keyFile, func(out io.Writer) error {
+			_, err := fmt.Fprintln(out, hex.EncodeToString(key))
+			return err
+		})
+		return key, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid state key in %s", keyFile)
+	}
+	return key, nil
+}
+
+func (s *syncState) write(out io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", b)
+	return err
+}
+
+// walkByPath returns the secrets of an endpoint by path, failing on an
+// incomplete walk since the missing paths would look deleted
+func walkByPath(e core.Endpoint) (map[string]core.Secret, error) {
+	var l core.SecretList
+	if err := core.Walk(e, &l); err != nil {
+		return nil, fmt.Errorf("%s: %v", e.GetRawURL(), err)
+	}
+	secrets := make(map[string]core.Secret, len(l))
+	for _, s := range l {
+		secrets[s.Path] = s
+	}
+	return secrets, nil
+}
+
+// bidirectional syncs two endpoints, propagating the paths changed on one
+// side since the last sync recorded in the state and reporting the paths
+// changed on both sides unless prefer resolves them
+type bidirectional struct {
+	src    core.Endpoint
+	dst    core.Endpoint
+	state  *syncState
+	prefer string
+	out    io.Writer
+	report *syncReport
+	failed int
+	key    []byte
+}
+
+// fingerprint is the HMAC of a secret recorded in the state, so that the
+// state file reveals nothing about the values without the key
+func (b *bidirectional) fingerprint(s core.Secret) string {
+	return hashSecret(hmac.New(sha256.New, b.key), s)
+}
+
+// run syncs the paths of both sides, failing before anything is changed
+// if a side could not be walked completely
+func (b *bidirectional) run() (conflicts int, err error) {
+	a, err := walkByPath(b.src)
+	if err != nil {
+		return 0, err
+	}
+	d, err := walkByPath(b.dst)
+	if err != nil {
+		return 0, err
+	}
+	if len(b.state.Paths) > 0 && (len(a) == 0 || len(d) == 0) {
+		// an unreachable endpoint looks empty, never delete everything because of it
+		return 0, fmt.Errorf("a side returned no secrets but %d paths were synced before, refusing to delete them", len(b.state.Paths))
+	}
+	paths := make(map[string]bool)
+	for path := range a {
+		paths[path] = true
+	}
+	for path := range d {
+		paths[path] = true
+	}
+	for path := range b.state.Paths {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	for _, path := range sorted {
+		srcSecret, inSrc := a[path]
+		dstSecret, inDst := d[path]
+		srcFP, dstFP := "", ""
+		if inSrc {
+			srcFP = b.fingerprint(srcSecret)
+		}
+		if inDst {
+			dstFP = b.fingerprint(dstSecret)
+		}
+		last := b.state.Paths[path]
+		switch {
+		case srcFP == dstFP:
+			b.record(path, srcFP)
+		case dstFP == last:
+			b.propagate(path, srcSecret, inSrc, b.dst, "=>")
+		case srcFP == last:
+			b.propagate(path, dstSecret, inDst, b.src, "<=")
+		default:
+			switch b.resolve(srcSecret, inSrc, dstSecret, inDst) {
+			case preferSource:
+				b.propagate(path, srcSecret, inSrc, b.dst, "=>")
+			case preferDestination:
+				b.propagate(path, dstSecret, inDst, b.src, "<=")
+			default:
+				conflicts++
+				b.report.add(reportActionConflict, path, path, nil, 0)
+				fmt.Fprintf(b.out, "%s !! changed on both sides, not synced\n", path)
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// resolve returns the side that wins a conflict, or preferNone
+func (b *bidirectional) resolve(srcSecret core.Secret, inSrc bool, dstSecret core.Secret, inDst bool) string {
+	if b.prefer != preferNewer {
+		return b.prefer
+	}
+	srcTime, dstTime := srcSecret.Metadata.Modified, dstSecret.Metadata.Modified
+	if !inSrc || !inDst || srcTime.IsZero() || dstTime.IsZero() || srcTime.Equal(dstTime) {
+		// deletes and secrets without a modification time cannot be compared
+		return preferNone
+	}
+	if srcTime.After(dstTime) {
+		return preferSource
+	}
+	return preferDestination
+}
+
+// propagate writes, or deletes if it no longer exists, a secret on the other side
+func (b *bidirectional) propagate(path string, secret core.Secret, exists bool, to core.Endpoint, arrow string) {
+	start := time.Now()
+	var err error
+	action := reportActionWrite
+	if exists {
+		err = to.Write(secret)
+	} else {
+		action = reportActionDelete
+		err = to.Delete(core.Secret{Path: path})
+	}
+	b.report.add(action, path, path, err, time.Since(start))
+	fmt.Fprintf(b.out, "%s %s %s %s (%v)\n", path, arrow, path, action, err)
+	if err != nil {
+		// the state keeps the last sync so that the path is retried
+		b.failed++
+		return
+	}
+	if exists {
+		b.record(path, b.fingerprint(secret))
+	} else {
+		b.record(path, "")
+	}
+}
+
+func (b *bidirectional) record(path string, fp string) {
+	if fp == "" {
+		delete(b.state.Paths, path)
+		return
+	}
+	b.state.Paths[path] = fp
+}
+
+// syncBidirectional runs a bidirectional sync between two endpoints
+func syncBidirectional(source core.Walker, srcArg string, dstArg string, report *syncReport) error {
+	switch syncFlags.prefer {
+	case preferNone, preferSource, preferDestination, preferNewer:
+	default:
+		return fmt.Errorf("unknown --prefer '%s', expected source, destination or newer", syncFlags.prefer)
+	}
+	if syncFlags.stateFile == "" {
+		return errors.New("--bidirectional needs a --state-file")
+	}
+	src, ok := source.(core.Endpoint)
+	if !ok {
+		return fmt.Errorf("%s cannot be written, --bidirectional needs two endpoints", srcArg)
+	}
+	state, err := loadSyncState(syncFlags.stateFile)
+	if err != nil {
+		return err
+	}
+	if len(state.Paths) > 0 && (state.Source != srcArg || state.Destination != dstArg) {
+		return fmt.Errorf("state file %s belongs to %s and %s", syncFlags.stateFile, state.Source, state.Destination)
+	}
+	state.Source, state.Destination = srcArg, dstArg
+	key, err := stateKey(syncFlags.stateFile)
+	if err != nil {
+		return err
+	}
+	if d, ok := src.(*backend.Dir); ok {
+		d.AllowInsecure = syncFlags.allowInsecure
+	}
+	dst, err := newDestination(dstArg, syncFlags.allowInsecure)
+	if err != nil {
+		return err
+	}
+	b := &bidirectional{src: src, dst: dst, state: state, prefer: syncFlags.prefer, out: syncOutput(report), report: report, key: key}
+	conflicts, err := b.run()
+	if err != nil {
+		// the state file is left as it was
+		return err
+	}
+	if err := commit(src, dstArg, srcArg); err != nil {
+		return err
+	}
+	if err := commit(dst, srcArg, dstArg); err != nil {
+		return err
+	}
+	if err := backend.WriteFileAtomic(syncFlags.stateFile, state.write); err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "%d paths changed on both sides, use --prefer to resolve them\n", conflicts)
+	}
+	if b.failed > 0 {
+		return fmt.Errorf("%d paths could not be synced", b.failed)
+	}
+	return nil
+}
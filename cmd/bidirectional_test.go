@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+)
+
+func TestBidirectional(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/a", Value: "1"})
+	src.Write(core.Secret{Path: "/secret/b", Value: "2"})
+	dst.Write(core.Secret{Path: "/secret/c", Value: "3"})
+
+	state := &syncState{Version: syncStateVersion, Paths: make(map[string]string)}
+	b := &bidirectional{src: src, dst: dst, state: state, out: ioutil.Discard}
+	if conflicts, err := b.run(); err != nil || conflicts != 0 {
+		t.Fatalf("Unexpected conflicts: %d %v\n", conflicts, err)
+	}
+	for _, e := range []core.Walker{src, dst} {
+		c := &collector{}
+		e.Walk(c)
+		if len(c.secrets) != 3 {
+			t.Fatalf("Expected both sides to have all the secrets: %v\n", c.secrets)
+		}
+	}
+
+	// one-sided changes in either direction, and a conflict
+	src.Write(core.Secret{Path: "/secret/a", Value: "one"})
+	dst.Delete(core.Secret{Path: "/secret/c"})
+	src.Write(core.Secret{Path: "/secret/b", Value: "src"})
+	dst.Write(core.Secret{Path: "/secret/b", Value: "dst"})
+	if conflicts, err := b.run(); err != nil || conflicts != 1 {
+		t.Fatalf("Expected one conflict but found: %d %v\n", conflicts, err)
+	}
+	c := &collector{}
+	dst.Walk(c)
+	expected := []core.Secret{{Path: "/secret/a", Value: "one"}, {Path: "/secret/b", Value: "dst"}}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+	c = &collector{}
+	src.Walk(c)
+	expected = []core.Secret{{Path: "/secret/a", Value: "one"}, {Path: "/secret/b", Value: "src"}}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+
+	b.prefer = preferDestination
+	if conflicts, err := b.run(); err != nil || conflicts != 0 {
+		t.Fatalf("Expected --prefer to resolve the conflict: %d %v\n", conflicts, err)
+	}
+	c = &collector{}
+	src.Walk(c)
+	if c.secrets[1].Value != "dst" {
+		t.Fatalf("Expected the destination to win: %v\n", c.secrets)
+	}
+}
+
+func TestBidirectional_ResolveNewer(t *testing.T) {
+	b := &bidirectional{prefer: preferNewer}
+	older := core.Secret{Path: "/a", Metadata: core.Metadata{Modified: time.Unix(100, 0)}}
+	newer := core.Secret{Path: "/a", Metadata: core.Metadata{Modified: time.Unix(200, 0)}}
+	if b.resolve(newer, true, older, true) != preferSource || b.resolve(older, true, newer, true) != preferDestination {
+		t.Fatal("Expected the newer secret to win")
+	}
+	if b.resolve(core.Secret{}, true, newer, true) != preferNone || b.resolve(newer, false, older, true) != preferNone {
+		t.Fatal("Expected conflicts without times or with deletes to stay unresolved")
+	}
+}
+
+// incompleteEndpoint is a directory whose walks fail to list a prefix
+type incompleteEndpoint struct {
+	*backend.Dir
+}
+
+func (e *incompleteEndpoint) Walk(visitor core.Visitor) {
+	e.Dir.Walk(visitor)
+	core.ReportError(visitor, "/secret/sub/", errors.New("permission denied"))
+}
+
+func TestBidirectional_IncompleteWalk(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/a", Value: "1"})
+	dst.Write(core.Secret{Path: "/secret/a", Value: "1"})
+	dst.Write(core.Secret{Path: "/secret/sub/b", Value: "2"})
+	state := &syncState{Version: syncStateVersion, Paths: make(map[string]string)}
+	b := &bidirectional{src: &incompleteEndpoint{src}, dst: dst, state: state, out: ioutil.Discard}
+	state.Paths["/secret/a"] = b.fingerprint(core.Secret{Path: "/secret/a", Value: "1"})
+	state.Paths["/secret/sub/b"] = b.fingerprint(core.Secret{Path: "/secret/sub/b", Value: "2"})
+
+	// b is missing from the incomplete walk of the source, it must not be deleted
+	if _, err := b.run(); err == nil {
+		t.Fatal("Expected an incomplete walk to fail the run")
+	}
+	// an empty side looks like everything was deleted
+	empty, emptyRoot := newTestDir(t)
+	defer os.RemoveAll(emptyRoot)
+	b = &bidirectional{src: empty, dst: dst, state: state, out: ioutil.Discard}
+	if _, err := b.run(); err == nil {
+		t.Fatal("Expected an empty side to fail the run")
+	}
+	c := &collector{}
+	dst.Walk(c)
+	if len(c.secrets) != 2 || len(state.Paths) != 2 {
+		t.Fatalf("Expected nothing to be deleted: %v %v\n", c.secrets, state.Paths)
+	}
+}
+
+// modifiedEndpoint is a directory whose secrets carry a modification time,
+// like the KV version 2 secrets walked from vault
+type modifiedEndpoint struct {
+	*backend.Dir
+	modified map[string]time.Time
+}
+
+func (e *modifiedEndpoint) Walk(visitor core.Visitor) {
+	c := &collector{}
+	e.Dir.Walk(c)
+	for _, s := range c.secrets {
+		s.Metadata.Modified = e.modified[s.Path]
+		visitor.Visit(s)
+	}
+}
+
+func TestBidirectional_PreferNewer(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/a", Value: "src"})
+	dst.Write(core.Secret{Path: "/secret/a", Value: "dst"})
+	src.Write(core.Secret{Path: "/secret/b", Value: "src"})
+	dst.Write(core.Secret{Path: "/secret/b", Value: "dst"})
+	b := &bidirectional{
+		src:    &modifiedEndpoint{src, map[string]time.Time{"/secret/a": time.Unix(200, 0), "/secret/b": time.Unix(100, 0)}},
+		dst:    &modifiedEndpoint{dst, map[string]time.Time{"/secret/a": time.Unix(100, 0), "/secret/b": time.Unix(200, 0)}},
+		state:  &syncState{Version: syncStateVersion, Paths: make(map[string]string)},
+		prefer: preferNewer,
+		out:    ioutil.Discard,
+	}
+	if conflicts, err := b.run(); err != nil || conflicts != 0 {
+		t.Fatalf("Expected the modification times to resolve the conflicts: %d %v\n", conflicts, err)
+	}
+	expected := []core.Secret{{Path: "/secret/a", Value: "src"}, {Path: "/secret/b", Value: "dst"}}
+	for _, e := range []core.Walker{src, dst} {
+		c := &collector{}
+		e.Walk(c)
+		if !reflect.DeepEqual(c.secrets, expected) {
+			t.Fatalf("Expected the newer secrets on both sides: %v\n", c.secrets)
+		}
+	}
+
+	// without modification times, as walked from KV version 1, nothing is resolved
+	src.Write(core.Secret{Path: "/secret/a", Value: "src2"})
+	dst.Write(core.Secret{Path: "/secret/a", Value: "dst2"})
+	b.src, b.dst = src, dst
+	if conflicts, err := b.run(); err != nil || conflicts != 1 {
+		t.Fatalf("Expected a conflict without modification times: %d %v\n", conflicts, err)
+	}
+}
+
+func TestStateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncrets-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(StateKeyEnv, os.Getenv(StateKeyEnv))
+	os.Unsetenv(StateKeyEnv)
+	file := dir + "/app.state"
+	key, err := stateKey(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file + ".key"); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a 0600 key file: %v %v\n", info, err)
+	}
+	if again, err := stateKey(file); err != nil || !reflect.DeepEqual(key, again) {
+		t.Fatalf("Expected the key to be kept: %v\n", err)
+	}
+	s := core.Secret{Path: "/secret/a", Value: "1"}
+	if (&bidirectional{key: key}).fingerprint(s) == fingerprint(s) {
+		t.Fatal("Expected the state fingerprint to be keyed")
+	}
+	os.Setenv(StateKeyEnv, "from-env")
+	if key, err := stateKey(file); err != nil || string(key) != "from-env" {
+		t.Fatalf("Expected the key of $%s: %s %v\n", StateKeyEnv, key, err)
+	}
+	ioutil.WriteFile(file, []byte(`{"version": 1, "paths": {}}`), 0600)
+	if _, err := loadSyncState(file); err == nil {
+		t.Fatal("Expected a version 1 state file to be refused")
+	}
+}
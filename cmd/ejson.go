@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	ejsonCmd.AddCommand(ejsonKeygenCmd)
+	RootCmd.AddCommand(ejsonCmd)
+}
+
+var ejsonCmd = &cobra.Command{
+	Use:   "ejson",
+	Short: "Manage ejson keys",
+	Long:  `Manage ejson keys`,
+}
+
+var ejsonKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an ejson keypair into EJSON_KEYDIR",
+	Long: `Generate an ejson keypair, writing the private key into EJSON_KEYDIR
+(default /opt/ejson/keys) and printing the public key`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pub, err := backend.GenerateEJSONKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(pub)
+	},
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var envFlags struct {
+	format    string
+	prefix    string
+	separator string
+	uppercase bool
+}
+
+func init() {
+	envCmd.Flags().StringVarP(&envFlags.format, "format", "f", backend.EnvFormatDotenv, "output format: dotenv, shell or fish")
+	addEnvNameFlags(envCmd)
+	RootCmd.AddCommand(envCmd)
+}
+
+// addEnvNameFlags adds the flags controlling how paths are mapped to names
+func addEnvNameFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&envFlags.prefix, "strip-prefix", "", "path prefix to strip from names (defaults to the source path)")
+	cmd.Flags().StringVar(&envFlags.separator, "separator", "_", "separator replacing '/' in names")
+	cmd.Flags().BoolVar(&envFlags.uppercase, "uppercase", true, "uppercase variable names")
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Print secrets as environment variables",
+	Long:  `Print secrets as environment variables`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := newSource(viper.GetViper(), args[0:1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := newEnvNameMapper(cmd, viper.GetViper(), src)
+		env := backend.NewEnvEndpoint(names, envFlags.format)
+		if err := core.Walk(src, env); err != nil {
+			log.Fatal(err)
+		}
+		if err := env.Marshal(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// newEnvNameMapper builds the path to name rules from the alias config and flags
+func newEnvNameMapper(cmd *cobra.Command, v *viper.Viper, src core.Walker) *backend.EnvNameMapper {
+	names := backend.NewEnvNameMapper(sourcePrefix(src))
+	// only endpoints with aliases in the config have an env section
+	if e, ok := src.(interface {
+		GetName() string
+		GetSection() string
+	}); ok {
+		names.Configure(v, e.GetSection(), e.GetName())
+	}
+	if cmd.Flags().Changed("strip-prefix") {
+		names.Prefix = envFlags.prefix
+	}
+	if cmd.Flags().Changed("separator") {
+		names.Separator = envFlags.separator
+	}
+	if cmd.Flags().Changed("uppercase") {
+		names.Uppercase = envFlags.uppercase
+	}
+	return names
+}
+
+// sourcePrefix returns the path being walked for endpoints that have one
+func sourcePrefix(src core.Walker) string {
+	if e, ok := src.(interface{ GetPath() string }); ok {
+		return e.GetPath()
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	addEnvNameFlags(execCmd)
+	// everything after the source belongs to the command being run
+	execCmd.Flags().SetInterspersed(false)
+	RootCmd.AddCommand(execCmd)
+}
+
+var execCmd = &cobra.Command{
+	Use:   "exec source -- command [args...]",
+	Short: "Run a command with secrets injected as environment variables",
+	Long: `Run a command with secrets injected as environment variables.
+The secrets are passed to the command in its environment and are never
+written to disk. Signals are forwarded to the command and syncrets exits
+with the exit code of the command.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		command := args[1:]
+		if command[0] == "--" {
+			command = command[1:]
+		}
+		if len(command) == 0 {
+			log.Fatal(errors.New("command to run is missing"))
+		}
+		src, err := newSource(viper.GetViper(), args[0:1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := newEnvNameMapper(cmd, viper.GetViper(), src)
+		env := backend.NewEnvEndpoint(names, backend.EnvFormatDotenv)
+		// never start the command with part of its environment
+		if err := core.Walk(src, env); err != nil {
+			log.Fatal(err)
+		}
+		vars, err := env.Vars()
+		if err != nil {
+			log.Fatal(err)
+		}
+		code, err := runWithEnv(command, vars)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(code)
+	},
+}
+
+// runWithEnv runs a command with vars added to the environment, forwarding
+// signals to it, and returns its exit code
+func runWithEnv(command []string, vars map[string]string) (int, error) {
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = os.Environ()
+	for name, value := range vars {
+		child.Env = append(child.Env, name+"="+value)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+	if err := child.Start(); err != nil {
+		return 0, err
+	}
+	log.Printf("Started %s (pid %d) with %d secrets\n", command[0], child.Process.Pid, len(vars))
+	go func() {
+		for sig := range signals {
+			log.Printf("Forwarding signal %v to pid %d\n", sig, child.Process.Pid)
+			child.Process.Signal(sig)
+		}
+	}()
+	err := child.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal()), nil
+			}
+			return status.ExitStatus(), nil
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// FingerprintKeyEnv holds the HMAC key of the fingerprint command if --key-file is not given
+const FingerprintKeyEnv = "SYNCRETS_FINGERPRINT_KEY"
+
+var fingerprintFlags struct {
+	keyFile string
+	compare bool
+}
+
+func init() {
+	fingerprintCmd.Flags().StringVar(&fingerprintFlags.keyFile, "key-file", "", "file holding the HMAC key, defaults to $"+FingerprintKeyEnv)
+	fingerprintCmd.Flags().BoolVar(&fingerprintFlags.compare, "compare", false, "report the values shared by several paths instead of printing digests")
+	RootCmd.AddCommand(fingerprintCmd)
+}
+
+var fingerprintCmd = &cobra.Command{
+	Use:   "fingerprint URL...",
+	Short: "Print HMAC-SHA256 digests of secrets without their values",
+	Long: `Print the HMAC-SHA256 digest of every field of the secrets under a key, so
+values can be compared between environments without being revealed. With
+--compare the values shared by several paths of all the URLs are reported.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := fingerprintKey(fingerprintFlags.keyFile, fingerprintFlags.compare)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f := &fingerprinter{key: key}
+		for _, arg := range args {
+			src, err := newSource(viper.GetViper(), []string{arg})
+			if err != nil {
+				log.Fatal(err)
+			}
+			f.source = arg
+			// a partial walk would hide the values shared with what it missed
+			if err := core.Walk(src, f); err != nil {
+				log.Fatalf("%s: %v", arg, err)
+			}
+		}
+		if fingerprintFlags.compare {
+			f.compare(os.Stdout)
+		} else {
+			f.print(os.Stdout, len(args) > 1)
+		}
+	},
+}
+
+// fingerprintKey reads the HMAC key, --compare never prints digests so it
+// falls back to a random key
+func fingerprintKey(file string, compare bool) ([]byte, error) {
+	if file == "" && os.Getenv(FingerprintKeyEnv) == "" && compare {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		return key, err
+	}
+	return readHMACKey(file, FingerprintKeyEnv)
+}
+
+// readHMACKey reads an HMAC key from a key file, or from an environment variable
+func readHMACKey(file, env string) ([]byte, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if key := strings.TrimSpace(string(b)); key != "" {
+			return []byte(key), nil
+		}
+		return nil, fmt.Errorf("the key file %s is empty", file)
+	}
+	if key := os.Getenv(env); key != "" {
+		return []byte(key), nil
+	}
+	return nil, fmt.Errorf("an HMAC key is required, use --key-file or $%s", env)
+}
+
+// fingerprintEntry is the digest of a field of a secret
+type fingerprintEntry struct {
+	source string
+	path   string
+	field  string
+	digest string
+}
+
+// fingerprinter collects the digests of the fields of the secrets it visits
+type fingerprinter struct {
+	key     []byte
+	source  string
+	entries []fingerprintEntry
+}
+
+// digest returns the hex HMAC-SHA256 of a value
+func (f *fingerprinter) digest(value string) string {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Visit ...
+func (f *fingerprinter) Visit(s core.Secret) {
+	data := s.Data()
+	fields := make([]string, 0, len(data))
+	for field := range data {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value := fmt.Sprintf("%v", data[field])
+		f.entries = append(f.entries, fingerprintEntry{f.source, s.Path, field, f.digest(value)})
+	}
+}
+
+// print writes a line per field, starting with the source if there are several
+func (f *fingerprinter) print(out io.Writer, withSource bool) {
+	for _, e := range f.entries {
+		if withSource {
+			fmt.Fprintf(out, "%s\t", e.source)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", e.path, e.field, e.digest)
+	}
+}
+
+// compare writes the groups of fields sharing a value, empty values are
+// equal everywhere and skipped. It returns the number of groups.
+func (f *fingerprinter) compare(out io.Writer) int {
+	empty := f.digest("")
+	groups := make(map[string][]fingerprintEntry)
+	var digests []string
+	for _, e := range f.entries {
+		if e.digest == empty {
+			continue
+		}
+		if _, ok := groups[e.digest]; !ok {
+			digests = append(digests, e.digest)
+		}
+		groups[e.digest] = append(groups[e.digest], e)
+	}
+	n := 0
+	for _, digest := range digests {
+		entries := groups[digest]
+		if len(entries) < 2 {
+			continue
+		}
+		n++
+		fmt.Fprintf(out, "identical value in %d places:\n", len(entries))
+		for _, e := range entries {
+			fmt.Fprintf(out, "  %s\t%s\t%s\n", e.source, e.path, e.field)
+		}
+	}
+	if n == 0 {
+		fmt.Fprintln(out, "no identical values found")
+	}
+	return n
+}
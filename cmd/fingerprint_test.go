@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+func TestFingerprinter(t *testing.T) {
+	f := &fingerprinter{key: []byte("key"), source: "prod"}
+	secretWalker{
+		{Path: "/secret/db", Value: "hunter2", Fields: map[string]string{"user": "admin"}},
+		{Path: "/secret/api", Fields: map[string]string{"token": "hunter2", "empty": ""}},
+	}.Walk(f)
+	f.source = "staging"
+	f.Visit(core.Secret{Path: "/secret/db", Value: "hunter2"})
+	f.Visit(core.Secret{Path: "/secret/empty", Fields: map[string]string{"x": ""}})
+
+	out := new(bytes.Buffer)
+	f.print(out, false)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	digest := f.digest("hunter2")
+	if len(lines) != 6 || lines[1] != "/secret/db\tvalue\t"+digest || len(digest) != 64 {
+		t.Fatalf("Unexpected digests: %s\n", out)
+	}
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), "admin") {
+		t.Fatalf("Expected no values in the output: %s\n", out)
+	}
+	if other := (&fingerprinter{key: []byte("other")}).digest("hunter2"); other == digest {
+		t.Fatal("Expected the digest to depend on the key")
+	}
+
+	out.Reset()
+	if n := f.compare(out); n != 1 {
+		t.Fatalf("Expected one group of identical values: %s\n", out)
+	}
+	expected := "identical value in 3 places:\n  prod\t/secret/db\tvalue\n  prod\t/secret/api\ttoken\n  staging\t/secret/db\tvalue\n"
+	if out.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, out.String())
+	}
+}
+
+func TestFingerprintKey(t *testing.T) {
+	os.Unsetenv(FingerprintKeyEnv)
+	if _, err := fingerprintKey("", false); err == nil {
+		t.Fatal("Expected a key to be required")
+	}
+	if key, err := fingerprintKey("", true); err != nil || len(key) != 32 {
+		t.Fatalf("Expected a random key for --compare: %v\n", err)
+	}
+	f, err := ioutil.TempFile("", "syncrets-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cret\n")
+	f.Close()
+	if key, err := fingerprintKey(f.Name(), false); err != nil || string(key) != "s3cret" {
+		t.Fatalf("Unexpected key: %s (%v)\n", key, err)
+	}
+}
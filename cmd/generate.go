@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// generated value types
+const (
+	generatePassword = "password"
+	generateHex      = "hex"
+	generateBase64   = "base64"
+	generateUUID     = "uuid"
+	generateEd25519  = "ed25519"
+	generateRSA      = "rsa"
+)
+
+// named character classes of --charset
+var charsetClasses = map[string]string{
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits":  "0123456789",
+	"symbols": "!#$%&()*+,-./:;<=>?@[]^_{|}~",
+	"alnum":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+}
+
+// generateRule describes how to generate a field, as configured for the
+// paths matching Pattern in the generate section of syncrets.yml
+type generateRule struct {
+	Pattern     string `mapstructure:"pattern"`
+	Type        string `mapstructure:"type"`
+	Field       string `mapstructure:"field"`
+	PublicField string `mapstructure:"public_field"`
+	Length      int    `mapstructure:"length"`
+	Charset     string `mapstructure:"charset"`
+	Bits        int    `mapstructure:"bits"`
+}
+
+var generateFlags struct {
+	rule  generateRule
+	force bool
+}
+
+func init() {
+	f := generateCmd.Flags()
+	f.StringVarP(&generateFlags.rule.Type, "type", "t", generatePassword, "type of value: password, hex, base64, uuid, ed25519 or rsa")
+	f.StringVar(&generateFlags.rule.Field, "field", core.ValueField, "field to generate")
+	f.StringVar(&generateFlags.rule.PublicField, "public-field", "", "field of the public key of keypairs, defaults to the field with a _public suffix")
+	f.IntVarP(&generateFlags.rule.Length, "length", "l", 32, "characters of passwords, bytes of hex and base64 values")
+	f.StringVar(&generateFlags.rule.Charset, "charset", "alnum", "characters of passwords, or classes among lower, upper, digits, symbols and alnum separated by commas")
+	f.IntVar(&generateFlags.rule.Bits, "bits", 3072, "size of rsa keys")
+	f.BoolVar(&generateFlags.force, "force", false, "overwrite an existing field")
+	RootCmd.AddCommand(generateCmd)
+}
+
+var generateCmd = &cobra.Command{
+	Use:   "generate URL",
+	Short: "Generate a random secret",
+	Long: `Generate a cryptographically random password, hex or base64 value, UUID or
+ed25519 or rsa keypair and write it to the secret at URL, keeping its other
+fields. Rules in the generate section of syncrets.yml set the defaults for the
+paths matching their pattern, flags override them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dst, err := backend.NewEndpoint(viper.GetViper(), args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules, err := loadGenerateRules(viper.GetViper())
+		if err != nil {
+			log.Fatal(err)
+		}
+		rule := generateFlags.rule
+		if r := matchGenerateRule(rules, dst.GetPath()); r != nil {
+			rule = mergeGenerateRule(cmd, *r)
+		}
+		if err := generateSecret(dst, rule, generateFlags.force); err != nil {
+			log.Fatal(err)
+		}
+		if committer, ok := dst.(core.Committer); ok {
+			if err := committer.Commit("syncrets generate " + args[0]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Printf("Generated %s %s at %s\n", rule.Type, rule.Field, dst.GetPath())
+	},
+}
+
+// loadGenerateRules reads the generate section of syncrets.yml
+func loadGenerateRules(v *viper.Viper) ([]generateRule, error) {
+	var rules []generateRule
+	if err := v.UnmarshalKey("generate", &rules); err != nil {
+		return nil, fmt.Errorf("invalid generate rules in syncrets.yml: %v", err)
+	}
+	for _, r := range rules {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid generate rule pattern '%s': %v", r.Pattern, err)
+		}
+	}
+	return rules, nil
+}
+
+// matchGenerateRule returns the first rule matching a path
+func matchGenerateRule(rules []generateRule, path string) *generateRule {
+	for i, r := range rules {
+		if regexp.MustCompile(r.Pattern).MatchString(path) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// mergeGenerateRule overrides a rule with the flags given on the command line,
+// the flag defaults fill in what the rule leaves out
+func mergeGenerateRule(cmd *cobra.Command, rule generateRule) generateRule {
+	flags := generateFlags.rule
+	set := func(name string, value *string, flag string) {
+		if *value == "" || cmd.Flags().Changed(name) {
+			*value = flag
+		}
+	}
+	set("type", &rule.Type, flags.Type)
+	set("field", &rule.Field, flags.Field)
+	set("public-field", &rule.PublicField, flags.PublicField)
+	set("charset", &rule.Charset, flags.Charset)
+	if rule.Length == 0 || cmd.Flags().Changed("length") {
+		rule.Length = flags.Length
+	}
+	if rule.Bits == 0 || cmd.Flags().Changed("bits") {
+		rule.Bits = flags.Bits
+	}
+	return rule
+}
+
+// generateSecret writes the generated fields to the secret at the path of
+// dst, refusing to overwrite existing fields unless forced
+func generateSecret(dst core.Endpoint, rule generateRule, force bool) error {
+	fields, err := generateFields(rule)
+	if err != nil {
+		return err
+	}
+	path := dst.GetPath()
+	reader, ok := dst.(core.Reader)
+	if !ok {
+		return fmt.Errorf("%s cannot read the secret at %s", dst.GetRawURL(), path)
+	}
+	existing, err := reader.Read(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %v", path, err)
+	}
+	data := map[string]interface{}{}
+	if existing != nil {
+		data = existing.Data()
+		path = existing.Path
+	}
+	for field, value := range fields {
+		if current, ok := data[field]; ok && current != "" && !force {
+			return fmt.Errorf("field %s of %s already exists, use --force to overwrite it", field, path)
+		}
+		data[field] = value
+	}
+	if err := dst.Write(core.NewSecret(path, data)); err != nil {
+		return err
+	}
+	// endpoints holding a single value per path cannot store every field
+	written, err := reader.Read(path)
+	if err != nil {
+		return fmt.Errorf("cannot read back %s: %v", path, err)
+	}
+	for field, value := range fields {
+		if written == nil || written.Data()[field] != value {
+			return fmt.Errorf("%s did not store the field %s of %s", dst.GetRawURL(), field, path)
+		}
+	}
+	return nil
+}
+
+// generateFields returns the fields generated by a rule
+func generateFields(rule generateRule) (map[string]string, error) {
+	if rule.Field == "" {
+		return nil, fmt.Errorf("no field to generate")
+	}
+	var value, public string
+	var err error
+	switch rule.Type {
+	case generatePassword:
+		value, err = randomString(rule.Length, charset(rule.Charset))
+	case generateHex, generateBase64:
+		if rule.Length <= 0 {
+			return nil, fmt.Errorf("invalid length %d", rule.Length)
+		}
+		b := make([]byte, rule.Length)
+		if _, err = rand.Read(b); err == nil {
+			value = hex.EncodeToString(b)
+			if rule.Type == generateBase64 {
+				value = base64.StdEncoding.EncodeToString(b)
+			}
+		}
+	case generateUUID:
+		value, err = randomUUID()
+	case generateEd25519, generateRSA:
+		value, public, err = generateKeypair(rule.Type, rule.Bits)
+	default:
+		return nil, fmt.Errorf("unknown type '%s', expected password, hex, base64, uuid, ed25519 or rsa", rule.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{rule.Field: value}
+	if public != "" {
+		publicField := rule.PublicField
+		if publicField == "" {
+			publicField = rule.Field + "_public"
+		}
+		fields[publicField] = public
+	}
+	return fields, nil
+}
+
+// charset expands the named classes of a --charset, anything else is taken literally
+func charset(s string) string {
+	var chars string
+	for _, name := range strings.Split(s, ",") {
+		class, ok := charsetClasses[name]
+		if !ok {
+			return s
+		}
+		chars += class
+	}
+	return chars
+}
+
+// randomString picks length characters uniformly from chars
+func randomString(length int, chars string) (string, error) {
+	if length <= 0 {
+		return "", fmt.Errorf("invalid length %d", length)
+	}
+	var set []rune
+	seen := make(map[rune]bool)
+	for _, r := range chars {
+		if !seen[r] {
+			seen[r] = true
+			set = append(set, r)
+		}
+	}
+	if len(set) < 2 {
+		return "", fmt.Errorf("the charset needs at least 2 different characters")
+	}
+	max := big.NewInt(int64(len(set)))
+	out := make([]rune, length)
+	for i := range out {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		out[i] = set[n.Int64()]
+	}
+	return string(out), nil
+}
+
+// randomUUID returns a version 4 UUID
+func randomUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// generateKeypair returns a PKCS #8 private key and a PKIX public key, both PEM encoded
+func generateKeypair(keyType string, bits int) (string, string, error) {
+	var private, public interface{}
+	switch keyType {
+	case generateEd25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		private, public = priv, pub
+	default:
+		if bits < 2048 {
+			return "", "", fmt.Errorf("rsa keys need at least 2048 bits, not %d", bits)
+		}
+		priv, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return "", "", err
+		}
+		private, public = priv, &priv.PublicKey
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return string(privatePEM), string(publicPEM), nil
+}
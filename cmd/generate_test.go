@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+)
+
+func TestGenerateFields(t *testing.T) {
+	cases := map[string]*regexp.Regexp{
+		generatePassword: regexp.MustCompile(`^[a-z]{20}$`),
+		generateHex:      regexp.MustCompile(`^[0-9a-f]{40}$`),
+		generateBase64:   regexp.MustCompile(`^[A-Za-z0-9+/]{27}=$`),
+		generateUUID:     regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+	}
+	for typ, expected := range cases {
+		fields, err := generateFields(generateRule{Type: typ, Field: "value", Length: 20, Charset: "lower"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !expected.MatchString(fields["value"]) {
+			t.Fatalf("Unexpected %s: %s\n", typ, fields["value"])
+		}
+	}
+	if _, err := generateFields(generateRule{Type: "bogus", Field: "value"}); err == nil {
+		t.Fatal("Expected an unknown type to be refused")
+	}
+	if _, err := generateFields(generateRule{Type: generatePassword, Field: "value", Length: 8, Charset: "aaa"}); err == nil {
+		t.Fatal("Expected a charset of a single character to be refused")
+	}
+	if s, _ := randomString(50, charset("digits,symbols")); strings.ContainsAny(s, "abcXYZ") {
+		t.Fatalf("Unexpected characters: %s\n", s)
+	}
+}
+
+func TestGenerateKeypair(t *testing.T) {
+	fields, err := generateFields(generateRule{Type: generateEd25519, Field: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(fields["key"]))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("Expected a PEM private key: %s\n", fields["key"])
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode([]byte(fields["key_public"]))
+	if block == nil {
+		t.Fatalf("Expected a PEM public key: %v\n", fields)
+	}
+	if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := generateKeypair(generateRSA, 1024); err == nil {
+		t.Fatal("Expected small rsa keys to be refused")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	_, root := newTestDir(t)
+	defer os.RemoveAll(root)
+	dst, err := backend.NewDirBackend([]string{backend.DirScheme + root + "?path=/secret/app/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := generateRule{Type: generateHex, Field: "value", Length: 16}
+	if err := generateSecret(dst, rule, false); err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	dst.Walk(c)
+	if len(c.secrets) != 1 || c.secrets[0].Path != "/secret/app/db" || len(c.secrets[0].Value) != 32 {
+		t.Fatalf("Unexpected secrets: %v\n", c.secrets)
+	}
+	first := c.secrets[0].Value
+	if err := generateSecret(dst, rule, false); err == nil {
+		t.Fatal("Expected the existing field to be kept without --force")
+	}
+	if err := generateSecret(dst, rule, true); err != nil {
+		t.Fatal(err)
+	}
+	c = &collector{}
+	dst.Walk(c)
+	if c.secrets[0].Value == first {
+		t.Fatal("Expected --force to overwrite the value")
+	}
+}
+
+func TestMatchGenerateRule(t *testing.T) {
+	rules := []generateRule{{Pattern: `/db$`, Field: "password", Length: 40}, {Pattern: `.*`, Type: generateUUID}}
+	r := matchGenerateRule(rules, "/secret/app/db")
+	if r == nil || r.Field != "password" {
+		t.Fatalf("Unexpected rule: %v\n", r)
+	}
+	merged := mergeGenerateRule(&cobra.Command{}, *r)
+	if merged.Type != generatePassword || merged.Length != 40 || merged.Field != "password" || merged.Charset != "alnum" {
+		t.Fatalf("Expected the rule with the flag defaults: %v\n", merged)
+	}
+	if r := matchGenerateRule(rules, "/secret/app/id"); r == nil || r.Type != generateUUID {
+		t.Fatalf("Unexpected rule: %v\n", r)
+	}
+}
+
+// unreadableEndpoint is a directory whose reads fail
+type unreadableEndpoint struct {
+	*backend.Dir
+}
+
+func (e *unreadableEndpoint) Read(path string) (*core.Secret, error) {
+	return nil, errors.New("permission denied")
+}
+
+func TestGenerateSecret_ReadError(t *testing.T) {
+	_, root := newTestDir(t)
+	defer os.RemoveAll(root)
+	dir, err := backend.NewDirBackend([]string{backend.DirScheme + root + "?path=/secret/app/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir.Write(core.Secret{Path: "/secret/app/db", Value: "existing"})
+	rule := generateRule{Type: generateHex, Field: "value", Length: 16}
+	if err := generateSecret(&unreadableEndpoint{dir}, rule, false); err == nil {
+		t.Fatal("Expected a failed read to fail rather than overwrite the secret")
+	}
+	c := &collector{}
+	dir.Walk(c)
+	if len(c.secrets) != 1 || c.secrets[0].Value != "existing" {
+		t.Fatalf("Expected the secret to be kept: %v\n", c.secrets)
+	}
+}
+
+// valueOnlyEndpoint is a directory silently dropping the fields other than value
+type valueOnlyEndpoint struct {
+	*backend.Dir
+}
+
+func (e *valueOnlyEndpoint) Write(s core.Secret) error {
+	return e.Dir.Write(core.Secret{Path: s.Path, Value: s.Value})
+}
+
+func TestGenerateSecret_Field(t *testing.T) {
+	_, root := newTestDir(t)
+	defer os.RemoveAll(root)
+	dir, err := backend.NewDirBackend([]string{backend.DirScheme + root + "?path=/secret/app/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := generateRule{Type: generatePassword, Field: "password", Length: 16, Charset: "alnum"}
+	if err := generateSecret(dir, rule, false); err == nil {
+		t.Fatal("Expected dir:// to refuse a field other than value")
+	}
+	if err := generateSecret(&valueOnlyEndpoint{dir}, rule, false); err == nil || !strings.Contains(err.Error(), "did not store the field password") {
+		t.Fatalf("Expected the dropped field to fail the command: %v\n", err)
+	}
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/drmdrew/syncrets/backend"
 	"github.com/drmdrew/syncrets/core"
@@ -12,7 +16,21 @@ import (
 	"github.com/spf13/viper"
 )
 
+// list output formats
+const (
+	listOutputText  = "text"
+	listOutputJSON  = "json"
+	listOutputJSONL = "jsonl"
+	listOutputTree  = "tree"
+	listOutputLong  = "long"
+)
+
+var listFlags struct {
+	output string
+}
+
 func init() {
+	listCmd.Flags().StringVarP(&listFlags.output, "output", "o", listOutputText, "output format: text, json, jsonl, tree or long")
 	RootCmd.AddCommand(listCmd)
 }
 
@@ -21,20 +39,147 @@ var listCmd = &cobra.Command{
 	Short: "List secrets from vault",
 	Long:  `List secrets from vault`,
 	Run: func(cmd *cobra.Command, args []string) {
-		list := &lister{os.Stdout}
+		switch listFlags.output {
+		case listOutputText, listOutputJSON, listOutputJSONL, listOutputTree, listOutputLong:
+		default:
+			log.Fatalf("unknown --output '%s', expected text, json, jsonl, tree or long", listFlags.output)
+		}
+		list := &lister{out: os.Stdout, output: listFlags.output}
 		srcArgs := args[0:1]
-		src, err := backend.NewVaultBackend(viper.GetViper(), srcArgs)
+		src, err := backend.NewEndpoint(viper.GetViper(), srcArgs)
 		if err != nil {
 			log.Fatal(err)
 		}
-		src.Walk(list)
+		walkErr := core.Walk(src, list)
+		if err := list.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		// the secrets found are listed, but an incomplete listing fails
+		if walkErr != nil {
+			log.Fatal(walkErr)
+		}
 	},
 }
 
+// listEntry describes a secret without its values
+type listEntry struct {
+	Path     string     `json:"path"`
+	Fields   []string   `json:"fields"`
+	Length   int        `json:"length"`
+	Version  int        `json:"version,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+func newListEntry(s core.Secret) listEntry {
+	e := listEntry{Path: s.Path, Length: s.Length(), Version: s.Metadata.Version}
+	for name := range s.Data() {
+		e.Fields = append(e.Fields, name)
+	}
+	sort.Strings(e.Fields)
+	if !s.Metadata.Modified.IsZero() {
+		modified := s.Metadata.Modified.UTC()
+		e.Modified = &modified
+	}
+	return e
+}
+
 type lister struct {
-	out io.Writer
+	out     io.Writer
+	output  string
+	entries []listEntry
 }
 
 func (l *lister) Visit(s core.Secret) {
-	fmt.Fprintf(l.out, "%s\n", s.Path)
+	e := newListEntry(s)
+	switch l.output {
+	case listOutputJSON, listOutputTree:
+		// printed at once by Flush
+		l.entries = append(l.entries, e)
+	case listOutputJSONL:
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(l.out, "%s\n", b)
+	case listOutputLong:
+		version, modified := "-", "-"
+		if e.Version != 0 {
+			version = fmt.Sprintf("v%d", e.Version)
+		}
+		if e.Modified != nil {
+			modified = e.Modified.Format(time.RFC3339)
+		}
+		fmt.Fprintf(l.out, "%-4s %-20s %6d  %s  %s\n", version, modified, e.Length, e.Path, strings.Join(e.Fields, ","))
+	default:
+		fmt.Fprintf(l.out, "%s\n", s.Path)
+	}
+}
+
+// Flush prints the formats that need every secret first
+func (l *lister) Flush() error {
+	switch l.output {
+	case listOutputJSON:
+		entries := l.entries
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		b, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(l.out, "%s\n", b)
+	case listOutputTree:
+		root := &listNode{}
+		for _, e := range l.entries {
+			root.add(e.Path)
+		}
+		root.print(l.out, 0)
+	}
+	return nil
+}
+
+// listNode is a step of a path in the tree output
+type listNode struct {
+	name     string
+	leaf     bool
+	count    int
+	children []*listNode
+}
+
+func (n *listNode) add(path string) {
+	n.count++
+	node := n
+	for _, step := range strings.Split(strings.Trim(path, "/"), "/") {
+		var child *listNode
+		for _, c := range node.children {
+			if c.name == step {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &listNode{name: step}
+			node.children = append(node.children, child)
+		}
+		child.count++
+		node = child
+	}
+	node.leaf = true
+}
+
+func (n *listNode) print(out io.Writer, depth int) {
+	children := append([]*listNode{}, n.children...)
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	indent := strings.Repeat("  ", depth)
+	for _, c := range children {
+		if c.leaf {
+			fmt.Fprintf(out, "%s%s\n", indent, c.name)
+		}
+		if len(c.children) > 0 {
+			// a prefix counts the secrets below it
+			below := c.count
+			if c.leaf {
+				below--
+			}
+			fmt.Fprintf(out, "%s%s/ (%d)\n", indent, c.name, below)
+			c.print(out, depth+1)
+		}
+	}
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+var listSecrets = []core.Secret{
+	{Path: "/secret/foo", Value: "bar"},
+	{Path: "/secret/foo/bar", Value: "foobar", Metadata: core.Metadata{Version: 3, Modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+	{Path: "/secret/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+}
+
+func listOutput(t *testing.T, output string) string {
+	buf := new(bytes.Buffer)
+	l := &lister{out: buf, output: output}
+	for _, s := range listSecrets {
+		l.Visit(s)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestLister_Outputs(t *testing.T) {
+	cases := []struct {
+		output   string
+		expected string
+	}{
+		{listOutputText, "/secret/foo\n/secret/foo/bar\n/secret/db\n"},
+		{listOutputJSONL, `{"path":"/secret/foo","fields":["value"],"length":3}
+{"path":"/secret/foo/bar","fields":["value"],"length":6,"version":3,"modified":"2024-01-02T03:04:05Z"}
+{"path":"/secret/db","fields":["password","user"],"length":12}
+`},
+		{listOutputLong, `-    -                         3  /secret/foo  value
+v3   2024-01-02T03:04:05Z      6  /secret/foo/bar  value
+-    -                        12  /secret/db  password,user
+`},
+		{listOutputTree, `secret/ (3)
+  db
+  foo
+  foo/ (1)
+    bar
+`},
+	}
+	for _, c := range cases {
+		if result := listOutput(t, c.output); result != c.expected {
+			t.Fatalf("%s: expected:\n%s\nbut result was:\n%s", c.output, c.expected, result)
+		}
+	}
+	if result := listOutput(t, listOutputJSON); result[0] != '[' {
+		t.Fatalf("Expected a JSON array but result was: %s", result)
+	}
+}
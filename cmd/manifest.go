@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ManifestKeyEnv holds the HMAC key of the manifest command if --key-file is not given
+const ManifestKeyEnv = "SYNCRETS_MANIFEST_KEY"
+
+// manifestVersion is the version of the manifest file format, version 1
+// manifests hashed with a salt stored in the manifest itself
+const manifestVersion = 2
+
+// manifestCheck is hashed into the manifest to detect a wrong key
+const manifestCheck = "syncrets manifest"
+
+var manifestFlags struct {
+	file    string
+	keyFile string
+}
+
+func init() {
+	manifestCmd.PersistentFlags().StringVarP(&manifestFlags.file, "file", "f", "syncrets.manifest.json", "manifest file")
+	manifestCmd.PersistentFlags().StringVar(&manifestFlags.keyFile, "key-file", "", "file holding the HMAC key, defaults to $"+ManifestKeyEnv)
+	manifestCmd.AddCommand(manifestCreateCmd)
+	manifestCmd.AddCommand(manifestVerifyCmd)
+	RootCmd.AddCommand(manifestCmd)
+}
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Track changes of secrets with a manifest of keyed hashes",
+	Long:  `Track changes of secrets with a manifest of keyed hashes`,
+}
+
+var manifestCreateCmd = &cobra.Command{
+	Use:   "create URL",
+	Short: "Write the keyed hash of every secret under a URL to the manifest",
+	Long: `Write the HMAC-SHA256 of every secret under a URL to the manifest, the
+manifest holds no values nor the key and can be committed. Recreating it with
+the same key only changes the hashes of the secrets that changed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := readHMACKey(manifestFlags.keyFile, ManifestKeyEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := newManifest(args[0], key)
+		src, err := newSource(viper.GetViper(), args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// a partial manifest would report the secrets it missed as removed
+		if err := core.Walk(src, m); err != nil {
+			log.Fatal(err)
+		}
+		if err := backend.WriteFileAtomic(manifestFlags.file, m.write); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Wrote %d paths to %s\n", len(m.Paths), manifestFlags.file)
+	},
+}
+
+var manifestVerifyCmd = &cobra.Command{
+	Use:   "verify [URL]",
+	Short: "Report the secrets added, removed or changed since the manifest was created",
+	Long: `Report the secrets added, removed or changed since the manifest was
+created, exiting with status 1 if anything changed. The URL defaults to the
+source the manifest was created from.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := readHMACKey(manifestFlags.keyFile, ManifestKeyEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m, err := loadManifest(manifestFlags.file, key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		source := m.Source
+		if len(args) > 0 {
+			source = args[0]
+		}
+		current := newManifest(source, key)
+		src, err := newSource(viper.GetViper(), []string{source})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := core.Walk(src, current); err != nil {
+			log.Fatal(err)
+		}
+		if m.diff(os.Stdout, current) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// manifest is the keyed hash of every path under a source
+type manifest struct {
+	Version int               `json:"version"`
+	Source  string            `json:"source"`
+	Check   string            `json:"check"`
+	Paths   map[string]string `json:"paths"`
+	key     []byte
+}
+
+// newManifest returns an empty manifest hashing with key
+func newManifest(source string, key []byte) *manifest {
+	return &manifest{
+		Version: manifestVersion,
+		Source:  source,
+		Check:   manifestHash(key, manifestCheck),
+		Paths:   make(map[string]string),
+		key:     key,
+	}
+}
+
+// manifestHash returns the hex HMAC-SHA256 of a string
+func manifestHash(key []byte, s string) string {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadManifest reads a manifest file created with key
+func loadManifest(file string, key []byte) (*manifest, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", file, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d in %s, create it again", m.Version, file)
+	}
+	if !hmac.Equal([]byte(m.Check), []byte(manifestHash(key, manifestCheck))) {
+		return nil, fmt.Errorf("the manifest %s was created with another key", file)
+	}
+	if m.Paths == nil {
+		m.Paths = make(map[string]string)
+	}
+	m.key = key
+	return m, nil
+}
+
+// Visit records the keyed hash of a secret
+func (m *manifest) Visit(s core.Secret) {
+	m.Paths[s.Path] = hashSecret(hmac.New(sha256.New, m.key), s)
+}
+
+func (m *manifest) write(out io.Writer) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", b)
+	return err
+}
+
+// diff writes the paths added, removed or changed in current, returning their number
+func (m *manifest) diff(out io.Writer, current *manifest) int {
+	paths := make([]string, 0, len(m.Paths)+len(current.Paths))
+	for p := range m.Paths {
+		paths = append(paths, p)
+	}
+	for p := range current.Paths {
+		if _, ok := m.Paths[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	n := 0
+	for _, p := range paths {
+		before, wasThere := m.Paths[p]
+		after, isThere := current.Paths[p]
+		switch {
+		case !wasThere:
+			fmt.Fprintf(out, "added    %s\n", p)
+		case !isThere:
+			fmt.Fprintf(out, "removed  %s\n", p)
+		case before != after:
+			fmt.Fprintf(out, "changed  %s\n", p)
+		default:
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		fmt.Fprintf(out, "%d paths unchanged\n", len(m.Paths))
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncrets-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := dir + "/syncrets.manifest.json"
+
+	key := []byte("manifest-key")
+	m := newManifest("vault://vault-a/secret/", key)
+	secretWalker{
+		{Path: "/secret/db", Value: "hunter2"},
+		{Path: "/secret/api", Fields: map[string]string{"token": "t1"}},
+		{Path: "/secret/old", Value: "x"},
+	}.Walk(m)
+	if err := backend.WriteFileAtomic(file, m.write); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(file)
+	if strings.Contains(string(b), "hunter2") || strings.Contains(string(b), "t1") || strings.Contains(string(b), string(key)) {
+		t.Fatalf("Expected no values nor key in the manifest: %s\n", b)
+	}
+	if _, err := loadManifest(file, []byte("other-key")); err == nil {
+		t.Fatal("Expected a manifest created with another key to be refused")
+	}
+
+	saved, err := loadManifest(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := newManifest(saved.Source, key)
+	secretWalker{
+		{Path: "/secret/db", Value: "hunter2"},
+		{Path: "/secret/api", Fields: map[string]string{"token": "t2"}},
+		{Path: "/secret/new", Value: "y"},
+	}.Walk(current)
+	out := new(bytes.Buffer)
+	if n := saved.diff(out, current); n != 3 {
+		t.Fatalf("Expected 3 changes: %s\n", out)
+	}
+	expected := "changed  /secret/api\nadded    /secret/new\nremoved  /secret/old\n"
+	if out.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, out.String())
+	}
+
+	out.Reset()
+	if n := saved.diff(out, saved); n != 0 || out.String() != "3 paths unchanged\n" {
+		t.Fatalf("Expected no changes: %s\n", out)
+	}
+	other := newManifest("", []byte("other-key"))
+	other.Visit(core.Secret{Path: "/secret/db", Value: "hunter2"})
+	if other.Paths["/secret/db"] == saved.Paths["/secret/db"] {
+		t.Fatal("Expected the hash to depend on the key")
+	}
+
+	ioutil.WriteFile(file, []byte(`{"version": 1, "salt": "00", "paths": {}}`), 0600)
+	if _, err := loadManifest(file, key); err == nil {
+		t.Fatal("Expected a version 1 manifest to be refused")
+	}
+}
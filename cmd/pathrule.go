@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// pathRuleConfig is a rule of the path_rules section of syncrets.yml
+type pathRuleConfig struct {
+	Pattern     string `mapstructure:"pattern"`
+	Replacement string `mapstructure:"replacement"`
+}
+
+// newPathRules compiles the path rules, in order
+func newPathRules(configs []pathRuleConfig) (core.PathRules, error) {
+	var rules core.PathRules
+	for _, config := range configs {
+		if config.Pattern == "" {
+			return nil, fmt.Errorf("path rule without a pattern")
+		}
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path rule pattern '%s': %v", config.Pattern, err)
+		}
+		rules = append(rules, core.PathRule{Pattern: pattern, Replacement: config.Replacement})
+	}
+	return rules, nil
+}
+
+// syncPathRules returns the path_rules section of syncrets.yml followed by the --map-path flags
+func syncPathRules(v *viper.Viper, flags []string) core.PathRules {
+	var configs []pathRuleConfig
+	if err := v.UnmarshalKey("path_rules", &configs); err != nil {
+		log.Fatalf("invalid path_rules in syncrets.yml: %v", err)
+	}
+	for _, flag := range flags {
+		i := strings.Index(flag, "=")
+		if i < 0 {
+			log.Fatalf("invalid --map-path '%s', expected PATTERN=REPLACEMENT", flag)
+		}
+		configs = append(configs, pathRuleConfig{Pattern: flag[:i], Replacement: flag[i+1:]})
+	}
+	rules, err := newPathRules(configs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rules
+}
+
+// mapped returns a walker mapping the paths of src, or src itself without rules
+func mapped(src core.Walker, rules core.PathRules) core.Walker {
+	if len(rules) == 0 {
+		return src
+	}
+	return &mappedSource{src, rules}
+}
+
+// mappedSource walks a source with its paths mapped by rules
+type mappedSource struct {
+	src   core.Walker
+	rules core.PathRules
+}
+
+// GetPath is the mapped prefix of the source
+func (m *mappedSource) GetPath() string {
+	return m.rules.Map(sourcePrefix(m.src))
+}
+
+// Walk reads the whole source first, so that a collision is reported as
+// an error of the walk before anything is visited
+func (m *mappedSource) Walk(visitor core.Visitor) {
+	secrets, err := m.mapAll(visitor)
+	if err != nil {
+		core.ReportError(visitor, m.GetPath(), err)
+		return
+	}
+	for _, s := range secrets {
+		visitor.Visit(s)
+	}
+}
+
+// mappedCollector collects the secrets of the source, passing the errors of
+// the walk on to the visitor of the mapped source
+type mappedCollector struct {
+	core.SecretList
+	visitor core.Visitor
+	rules   core.PathRules
+	skipped []string
+}
+
+func (c *mappedCollector) VisitError(path string, err error) {
+	core.ReportError(c.visitor, path, err)
+}
+
+// Unchanged asks the visitor of the mapped source about the mapped path,
+// remembering the skipped source paths for the collision check
+func (c *mappedCollector) Unchanged(path string, version int) bool {
+	skipper, ok := c.visitor.(core.VersionSkipper)
+	if !ok || !skipper.Unchanged(c.rules.Map(path), version) {
+		return false
+	}
+	c.skipped = append(c.skipped, path)
+	return true
+}
+
+// mapAll maps the paths of all the secrets of the source, failing if two
+// source paths map to the same destination path
+func (m *mappedSource) mapAll(visitor core.Visitor) ([]core.Secret, error) {
+	all := &mappedCollector{visitor: visitor, rules: m.rules}
+	m.src.Walk(all)
+	sources := make(map[string][]string)
+	for _, path := range all.skipped {
+		p := m.rules.Map(path)
+		sources[p] = append(sources[p], path)
+	}
+	secrets := make([]core.Secret, 0, len(all.SecretList))
+	for _, s := range all.SecretList {
+		p := m.rules.Map(s.Path)
+		sources[p] = append(sources[p], s.Path)
+		if p != s.Path && s.Source == "" {
+			s.Source = s.Path
+		}
+		s.Path = p
+		secrets = append(secrets, s)
+	}
+	var collisions []string
+	for p, paths := range sources {
+		if len(paths) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s <= %s", p, strings.Join(paths, ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("path rules map several source paths to the same destination:\n%s", strings.Join(collisions, "\n"))
+	}
+	return secrets, nil
+}
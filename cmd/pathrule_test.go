@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+func TestSyncPathRules(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	config := `
+path_rules:
+  - pattern: '^/secret/([^/]+)/(prod|dev)/'
+    replacement: /secret/$2/$1/
+`
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	rules := syncPathRules(v, []string{"^/old/=/new/"})
+	src := secretWalker{
+		{Path: "/secret/web/prod/db", Value: "a"},
+		{Path: "/old/x", Value: "b"},
+	}
+	c := &collector{}
+	mapped(src, rules).Walk(c)
+	if len(c.secrets) != 2 || c.secrets[0].Path != "/secret/prod/web/db" || c.secrets[1].Path != "/new/x" {
+		t.Fatalf("Unexpected mapped secrets: %v\n", c.secrets)
+	}
+}
+
+func TestMappedSource_Collision(t *testing.T) {
+	rules, err := newPathRules([]pathRuleConfig{{Pattern: `^/secret/(dev|qa)/`, Replacement: "/secret/test/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &mappedSource{secretWalker{
+		{Path: "/secret/dev/db", Value: "a"},
+		{Path: "/secret/qa/db", Value: "b"},
+		{Path: "/secret/dev/api", Value: "c"},
+	}, rules}
+	_, err = src.mapAll(&collector{})
+	if err == nil || !strings.Contains(err.Error(), "/secret/test/db <= /secret/dev/db, /secret/qa/db") {
+		t.Fatalf("Expected a collision error but found: %v\n", err)
+	}
+	if _, err := newPathRules([]pathRuleConfig{{Pattern: "("}}); err == nil {
+		t.Fatal("Expected an invalid pattern to be refused")
+	}
+	if _, ok := mapped(secretWalker{}, nil).(secretWalker); !ok {
+		t.Fatal("Expected the source itself without rules")
+	}
+}
+
+// versionedWalker skips the secrets a core.VersionSkipper already holds, like
+// the walks of KV version 2 secrets
+type versionedWalker []core.Secret
+
+func (w versionedWalker) Walk(visitor core.Visitor) {
+	skipper, _ := visitor.(core.VersionSkipper)
+	for _, s := range w {
+		if skipper != nil && skipper.Unchanged(s.Path, s.Metadata.Version) {
+			continue
+		}
+		visitor.Visit(s)
+	}
+}
+
+func TestMappedSource_Unchanged(t *testing.T) {
+	rules, err := newPathRules([]pathRuleConfig{{Pattern: `^/secret/dev/`, Replacement: "/secret/test/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := versionedWalker{
+		{Path: "/secret/dev/db", Value: "a", Metadata: core.Metadata{Version: 1}},
+		{Path: "/secret/test/db", Value: "b", Metadata: core.Metadata{Version: 1}},
+	}
+	w := newWatcher()
+	if _, _, err := w.pass(&mappedSource{src[:1], rules}); err != nil {
+		t.Fatal(err)
+	}
+	changed, removed, err := w.pass(&mappedSource{src[:1], rules})
+	if err != nil || len(changed) != 0 || len(removed) != 0 {
+		t.Fatalf("Expected the known version to be skipped: %v %v %v\n", changed, removed, err)
+	}
+	// a skipped secret still collides with a new secret mapped to its path
+	if _, _, err := w.pass(&mappedSource{src, rules}); err == nil {
+		t.Fatal("Expected a collision with a skipped secret")
+	}
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var renderFlags struct {
+	output        string
+	base          string
+	allowInsecure bool
+}
+
+func init() {
+	renderCmd.Flags().StringVarP(&renderFlags.output, "output", "o", "", "file to write, defaults to stdout")
+	renderCmd.Flags().StringVar(&renderFlags.base, "base", "", "URL that secret and secrets arguments without a scheme are relative to")
+	renderCmd.Flags().BoolVar(&renderFlags.allowInsecure, "allow-insecure-output", false, "allow writing the rendered file into a world-readable directory")
+	RootCmd.AddCommand(renderCmd)
+}
+
+var renderCmd = &cobra.Command{
+	Use:   "render template",
+	Short: "Render a Go template with secrets",
+	Long: `Render a Go text/template with secrets, e.g.
+  password={{ secret "vault://vault-a/secret/db" "password" }}
+  {{ range secrets "vault://vault-a/secret/app/" }}{{ .Name }}={{ .Value | b64enc }}
+  {{ end }}`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		text, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		r := newRenderer(viper.GetViper(), renderFlags.base)
+		tmpl, err := template.New(args[0]).Funcs(r.funcs()).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			log.Fatal(err)
+		}
+		render := func(out io.Writer) error {
+			return tmpl.Execute(out, nil)
+		}
+		if renderFlags.output == "" {
+			if err := render(os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if err := backend.CheckOutputDir(renderFlags.output, renderFlags.allowInsecure); err != nil {
+			log.Fatal(err)
+		}
+		if err := backend.WriteFileAtomic(renderFlags.output, render); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// renderSecret is a secret as seen by templates, Name is its path relative
+// to the prefix given to secrets
+type renderSecret struct {
+	Path   string
+	Name   string
+	Value  string
+	Fields map[string]string
+}
+
+// renderer reads the secrets of the endpoints used by a template once
+type renderer struct {
+	v     *viper.Viper
+	base  string
+	cache map[string]*renderWalk
+}
+
+// renderWalk holds the secrets walked at a URL
+type renderWalk struct {
+	path    string
+	secrets core.SecretList
+}
+
+func newRenderer(v *viper.Viper, base string) *renderer {
+	return &renderer{v: v, base: base, cache: make(map[string]*renderWalk)}
+}
+
+func (r *renderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"secret":  r.secret,
+		"secrets": r.secrets,
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		},
+	}
+}
+
+// resolve returns the URL for an argument, relative to --base without a scheme
+func (r *renderer) resolve(arg string) (string, error) {
+	if strings.Contains(arg, "://") {
+		return arg, nil
+	}
+	if r.base == "" {
+		return "", fmt.Errorf("'%s' has no scheme and no --base is given", arg)
+	}
+	return strings.TrimSuffix(r.base, "/") + "/" + strings.TrimPrefix(arg, "/"), nil
+}
+
+// walk returns the secrets of an endpoint with the path of its URL
+func (r *renderer) walk(arg string) ([]core.Secret, string, error) {
+	u, err := r.resolve(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	if w, ok := r.cache[u]; ok {
+		return w.secrets, w.path, nil
+	}
+	src, err := newSource(r.v, []string{u})
+	if err != nil {
+		return nil, "", err
+	}
+	w := &renderWalk{path: sourcePrefix(src)}
+	if err := core.Walk(src, &w.secrets); err != nil {
+		return nil, "", fmt.Errorf("%s: %v", u, err)
+	}
+	r.cache[u] = w
+	return w.secrets, w.path, nil
+}
+
+// secret returns a field of the secret at a URL, the value field by default
+func (r *renderer) secret(arg string, field ...string) (string, error) {
+	secrets, path, err := r.walk(arg)
+	if err != nil {
+		return "", err
+	}
+	name := core.ValueField
+	if len(field) > 0 {
+		name = field[0]
+	}
+	for _, s := range secrets {
+		if strings.Trim(s.Path, "/") != strings.Trim(path, "/") {
+			continue
+		}
+		if value, ok := s.Data()[name]; ok {
+			return fmt.Sprintf("%v", value), nil
+		}
+		return "", fmt.Errorf("secret %s has no field '%s'", arg, name)
+	}
+	return "", fmt.Errorf("no secret at %s", arg)
+}
+
+// secrets returns the secrets under the prefix of a URL
+func (r *renderer) secrets(arg string) ([]renderSecret, error) {
+	secrets, path, err := r.walk(arg)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(path, "/")
+	var result []renderSecret
+	for _, s := range secrets {
+		name := strings.Trim(s.Path, "/")
+		if prefix != "" {
+			name = strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+		}
+		result = append(result, renderSecret{Path: s.Path, Name: name, Value: s.Value, Fields: s.Fields})
+	}
+	return result, nil
+}
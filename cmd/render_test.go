@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+func TestRenderer(t *testing.T) {
+	d, root := newTestDir(t)
+	defer os.RemoveAll(root)
+	d.Write(core.Secret{Path: "/secret/db", Value: "hunter2"})
+	d.Write(core.Secret{Path: "/secret/app/a", Value: "1"})
+	d.Write(core.Secret{Path: "/secret/app/b", Value: "2"})
+
+	base := backend.DirScheme + root
+	r := newRenderer(viper.New(), base)
+	text := `db={{ secret "` + base + `?path=/secret/db" }}
+{{ range secrets "?path=/secret/app/" }}{{ .Name }}={{ .Value | b64enc }}
+{{ end }}`
+	tmpl, err := template.New("test").Funcs(r.funcs()).Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "db=hunter2\na=MQ==\nb=Mg==\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, buf.String())
+	}
+
+	if _, err := r.secret(base+"?path=/secret/db", "password"); err == nil || !strings.Contains(err.Error(), "no field") {
+		t.Fatalf("Expected a missing field error but got: %v\n", err)
+	}
+	if _, err := newRenderer(viper.New(), "").secret("secret/db"); err == nil {
+		t.Fatal("Expected an error for a relative argument without --base")
+	}
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+// sync report actions
+const (
+	reportActionWrite    = "write"
+	reportActionExport   = "export"
+	reportActionDelete   = "delete"
+	reportActionConflict = "conflict"
+	reportActionError    = "error"
+)
+
+// syncResult is the outcome of syncing one path
+type syncResult struct {
+	Action      string  `json:"action"`
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Error       string  `json:"error,omitempty"`
+	DurationMS  float64 `json:"duration_ms"`
+}
+
+// syncSummary counts the results of a sync
+type syncSummary struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Total       int     `json:"total"`
+	Written     int     `json:"written"`
+	Exported    int     `json:"exported"`
+	Deleted     int     `json:"deleted"`
+	Conflicts   int     `json:"conflicts"`
+	Failed      int     `json:"failed"`
+	DurationMS  float64 `json:"duration_ms"`
+}
+
+// syncReport collects the results of a sync, a nil report collects nothing
+type syncReport struct {
+	Results []syncResult `json:"results"`
+	Summary syncSummary  `json:"summary"`
+	start   time.Time
+}
+
+func newSyncReport(src string, dst string) *syncReport {
+	return &syncReport{
+		Results: []syncResult{},
+		Summary: syncSummary{Source: src, Destination: dst},
+		start:   time.Now(),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *syncReport) add(action string, src string, dst string, err error, d time.Duration) {
+	if r == nil {
+		return
+	}
+	result := syncResult{Action: action, Source: src, Destination: dst, DurationMS: milliseconds(d)}
+	if err != nil {
+		result.Action = reportActionError
+		result.Error = err.Error()
+	}
+	r.Results = append(r.Results, result)
+	r.Summary.Total++
+	switch result.Action {
+	case reportActionWrite:
+		r.Summary.Written++
+	case reportActionExport:
+		r.Summary.Exported++
+	case reportActionDelete:
+		r.Summary.Deleted++
+	case reportActionConflict:
+		r.Summary.Conflicts++
+	case reportActionError:
+		r.Summary.Failed++
+	}
+}
+
+// fail records an error that stopped the sync
+func (r *syncReport) fail(err error) {
+	if r != nil && err != nil {
+		r.add(reportActionError, r.Summary.Source, r.Summary.Destination, err, 0)
+	}
+}
+
+// visitor records every secret visited by v as exported to a file
+func (r *syncReport) visitor(v core.Visitor, file string) core.Visitor {
+	if r == nil {
+		return v
+	}
+	return &reportVisitor{v, r, file}
+}
+
+// write the report as a JSON document
+func (r *syncReport) write(out io.Writer) error {
+	r.Summary.DurationMS = milliseconds(time.Since(r.start))
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", b)
+	return err
+}
+
+type reportVisitor struct {
+	visitor core.Visitor
+	report  *syncReport
+	file    string
+}
+
+func (v *reportVisitor) Visit(s core.Secret) {
+	start := time.Now()
+	v.visitor.Visit(s)
+	v.report.add(reportActionExport, s.SourcePath(), v.file, nil, time.Since(start))
+}
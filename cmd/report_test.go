@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+)
+
+func TestSyncReport(t *testing.T) {
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	report := newSyncReport("dir:///src", "dir:///dst")
+	sync := &syncer{out: ioutil.Discard, dst: dst, report: report}
+	sync.Visit(core.Secret{Path: "/secret/foo", Value: "bar"})
+	sync.Visit(core.Secret{Path: "/../outside", Value: "x"})
+	report.visitor(&collector{}, "out.json").Visit(core.Secret{Path: "/secret/foo", Value: "bar"})
+
+	buf := new(bytes.Buffer)
+	if err := report.write(buf); err != nil {
+		t.Fatal(err)
+	}
+	var result syncReport
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, r := range result.Results {
+		actions = append(actions, r.Action)
+	}
+	if len(actions) != 3 || actions[0] != reportActionWrite || actions[1] != reportActionError || actions[2] != reportActionExport {
+		t.Fatalf("Unexpected actions: %v\n", actions)
+	}
+	if result.Results[1].Error == "" {
+		t.Fatal("Expected the error of the failed write")
+	}
+	s := result.Summary
+	if s.Total != 3 || s.Written != 1 || s.Failed != 1 || s.Exported != 1 || s.Source != "dir:///src" {
+		t.Fatalf("Unexpected summary: %+v\n", s)
+	}
+}
+
+func TestSyncReport_Nil(t *testing.T) {
+	var report *syncReport
+	c := &collector{}
+	if report.visitor(c, "out.json") != c {
+		t.Fatal("Expected a nil report to leave the visitor alone")
+	}
+	report.add(reportActionWrite, "/a", "/a", nil, 0)
+}
+
+func TestSyncReport_FailingDestination(t *testing.T) {
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	reportFile := dstRoot + "/report.json"
+	defer func(file string) { syncFlags.reportFile = file }(syncFlags.reportFile)
+	syncFlags.reportFile = reportFile
+
+	report := newSyncReport("src", "dst")
+	src := secretWalker{{Path: "/secret/a", Value: "1"}, {Path: "/../outside", Value: "x"}}
+	err := syncWalk(src, dst, "src", "dst", report)
+	if err == nil {
+		t.Fatal("Expected the failed write to fail the sync")
+	}
+	report.fail(err)
+	writeReport(report)
+
+	b, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("Expected the report to be written: %v\n", err)
+	}
+	var result syncReport
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatal(err)
+	}
+	if s := result.Summary; s.Written != 1 || s.Failed != 2 {
+		t.Fatalf("Expected the failed write and the failed sync in the report: %+v\n", s)
+	}
+	if _, err := syncToFile(nil, src, dstRoot+"/missing/out.json", report); err == nil {
+		t.Fatal("Expected an export to a missing directory to fail")
+	}
+}
+
+func TestSyncReport_PathRules(t *testing.T) {
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	rules, err := newPathRules([]pathRuleConfig{{Pattern: `^/secret/dev/`, Replacement: "/secret/test/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newSyncReport("src", "dst")
+	src := mapped(secretWalker{{Path: "/secret/dev/db", Value: "1"}, {Path: "/secret/other", Value: "2"}}, rules)
+	if err := syncWalk(src, dst, "src", "dst", report); err != nil {
+		t.Fatal(err)
+	}
+	report.visitor(&collector{}, "out.json").Visit(core.Secret{Path: "/secret/test/db", Source: "/secret/dev/db"})
+	expected := [][2]string{
+		{"/secret/dev/db", "/secret/test/db"},
+		{"/secret/other", "/secret/other"},
+		{"/secret/dev/db", "out.json"},
+	}
+	if len(report.Results) != len(expected) {
+		t.Fatalf("Unexpected results: %+v\n", report.Results)
+	}
+	for i, r := range report.Results {
+		if r.Source != expected[i][0] || r.Destination != expected[i][1] {
+			t.Fatalf("Expected %s => %s but result was: %+v\n", expected[i][0], expected[i][1], r)
+		}
+	}
+}
@@ -22,12 +22,17 @@ var rmCmd = &cobra.Command{
 	Long:  `Remove secrets from vault`,
 	Run: func(cmd *cobra.Command, args []string) {
 		srcArgs := args[0:1]
-		src, err := backend.NewVaultBackend(viper.GetViper(), srcArgs)
+		src, err := backend.NewEndpoint(viper.GetViper(), srcArgs)
 		if err != nil {
 			log.Fatal(err)
 		}
 		rm := &remover{os.Stdout, src}
 		src.Walk(rm)
+		if committer, ok := src.(core.Committer); ok {
+			if err := committer.Commit("syncrets rm " + srcArgs[0]); err != nil {
+				log.Fatal(err)
+			}
+		}
 	},
 }
 
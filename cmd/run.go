@@ -0,0 +1,360 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runFlags struct {
+	all           bool
+	scheduled     bool
+	allowInsecure bool
+}
+
+func init() {
+	runCmd.Flags().BoolVar(&runFlags.all, "all", false, "run all the jobs")
+	runCmd.Flags().BoolVar(&runFlags.scheduled, "scheduled", false, "keep running the jobs on their schedule until interrupted")
+	runCmd.Flags().BoolVar(&runFlags.allowInsecure, "allow-insecure-output", false, "allow writing plaintext secrets into world-readable directories")
+	RootCmd.AddCommand(runCmd)
+}
+
+// job is a named sync configured in the jobs section of syncrets.yml
+type job struct {
+	Name        string
+	Source      string   `mapstructure:"source"`
+	Destination string   `mapstructure:"destination"`
+	Include     []string `mapstructure:"include"`
+	Exclude     []string `mapstructure:"exclude"`
+	Prefix      struct {
+		From string `mapstructure:"from"`
+		To   string `mapstructure:"to"`
+	} `mapstructure:"prefix"`
+	Delete     bool                `mapstructure:"delete"`
+	Schedule   string              `mapstructure:"schedule"`
+	Transforms []map[string]string `mapstructure:"transforms"`
+	PathRules  []pathRuleConfig    `mapstructure:"path_rules"`
+	interval   time.Duration
+	transforms core.Transforms
+	rules      core.PathRules
+}
+
+// loadJobs returns the jobs configured in syncrets.yml by name
+func loadJobs(v *viper.Viper) (map[string]*job, error) {
+	jobs := make(map[string]*job)
+	if err := v.UnmarshalKey("jobs", &jobs); err != nil {
+		return nil, err
+	}
+	for name, j := range jobs {
+		j.Name = name
+		if j.Source == "" || j.Destination == "" {
+			return nil, fmt.Errorf("job %s needs a source and a destination", name)
+		}
+		if j.Schedule != "" {
+			interval, err := time.ParseDuration(j.Schedule)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("job %s has an invalid schedule '%s', expected a duration like 15m", name, j.Schedule)
+			}
+			j.interval = interval
+		}
+		if j.Delete && isFileDestination(j.Destination) {
+			return nil, fmt.Errorf("job %s cannot delete from the file %s, files are rewritten with the source secrets", name, j.Destination)
+		}
+		transforms, err := newTransforms(j.Transforms)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %v", name, err)
+		}
+		j.transforms = transforms
+		if j.rules, err = newPathRules(j.PathRules); err != nil {
+			return nil, fmt.Errorf("job %s: %v", name, err)
+		}
+		for _, pattern := range append(append([]string{}, j.Include...), j.Exclude...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("job %s has an invalid pattern '%s': %v", name, pattern, err)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [job]...",
+	Short: "Run sync jobs configured in syncrets.yml",
+	Long:  `Run the named sync jobs, or all of them with --all, configured in the jobs section of syncrets.yml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jobs, err := loadJobs(viper.GetViper())
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := args
+		if runFlags.all {
+			names = nil
+			for name := range jobs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		if len(names) == 0 {
+			log.Fatal("no job given, name one or more jobs or use --all")
+		}
+		var selected []*job
+		for _, name := range names {
+			j, ok := jobs[name]
+			if !ok {
+				log.Fatalf("no job named '%s' in syncrets.yml", name)
+			}
+			selected = append(selected, j)
+		}
+		if runFlags.scheduled {
+			runScheduled(cmd, selected)
+			return
+		}
+		failed := 0
+		for _, j := range selected {
+			if err := runJob(cmd, j); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			log.Fatalf("%d of %d jobs failed", failed, len(selected))
+		}
+	},
+}
+
+// matchPath reports whether a glob matches a path or one of its parents
+func matchPath(pattern string, p string) bool {
+	for ; p != "/" && p != "." && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selected applies the include and exclude filters of a job to a path
+func (j *job) selected(p string) bool {
+	for _, pattern := range j.Exclude {
+		if matchPath(pattern, p) {
+			return false
+		}
+	}
+	if len(j.Include) == 0 {
+		return true
+	}
+	for _, pattern := range j.Include {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapPath replaces the prefix.from of a path with prefix.to
+func (j *job) mapPath(p string) string {
+	if j.Prefix.From == "" || !backend.UnderPrefix(p, j.Prefix.From) {
+		return p
+	}
+	rest := strings.TrimPrefix(strings.Trim(p, "/"), strings.Trim(j.Prefix.From, "/"))
+	return "/" + strings.Trim(strings.Trim(j.Prefix.To, "/")+"/"+strings.Trim(rest, "/"), "/")
+}
+
+// unmapPath is the inverse of mapPath, the source path of a destination path
+func (j *job) unmapPath(p string) string {
+	if j.Prefix.From == "" || !backend.UnderPrefix(p, j.Prefix.To) {
+		return p
+	}
+	rest := strings.TrimPrefix(strings.Trim(p, "/"), strings.Trim(j.Prefix.To, "/"))
+	return "/" + strings.Trim(strings.Trim(j.Prefix.From, "/")+"/"+strings.Trim(rest, "/"), "/")
+}
+
+// jobSource walks the source of a job, filtering and mapping the paths
+type jobSource struct {
+	src core.Walker
+	job *job
+}
+
+// GetPath is the source prefix as seen by the destination
+func (s *jobSource) GetPath() string {
+	return s.job.mapPath(sourcePrefix(s.src))
+}
+
+// Walk ...
+func (s *jobSource) Walk(visitor core.Visitor) {
+	s.src.Walk(&jobVisitor{s.job, visitor})
+}
+
+type jobVisitor struct {
+	job     *job
+	visitor core.Visitor
+}
+
+func (v *jobVisitor) Visit(s core.Secret) {
+	if !v.job.selected(s.Path) {
+		log.Printf("Job %s skips %s\n", v.job.Name, s.Path)
+		return
+	}
+	if p := v.job.mapPath(s.Path); p != s.Path {
+		if s.Source == "" {
+			s.Source = s.Path
+		}
+		s.Path = p
+	}
+	v.visitor.Visit(s)
+}
+
+// VisitError passes on the errors of the walk
+func (v *jobVisitor) VisitError(path string, err error) {
+	core.ReportError(v.visitor, path, err)
+}
+
+// pathRecorder remembers the paths visited by a walk
+type pathRecorder struct {
+	visitor core.Visitor
+	paths   map[string]bool
+}
+
+func (r *pathRecorder) Visit(s core.Secret) {
+	r.paths[s.Path] = true
+	r.visitor.Visit(s)
+}
+
+// runJob syncs the source of a job to its destination and writes its
+// report, recording the error that stopped the job in the report
+func runJob(cmd *cobra.Command, j *job) error {
+	fmt.Fprintf(os.Stderr, "Running job %s: %s => %s\n", j.Name, j.Source, j.Destination)
+	report := newReport(j.Source, j.Destination)
+	err := syncJob(cmd, j, report)
+	report.fail(err)
+	writeReport(report)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", j.Name, err)
+	}
+	return nil
+}
+
+// syncJob syncs the source of a job to its destination, deleting the
+// destination secrets missing from the source with delete: true
+func syncJob(cmd *cobra.Command, j *job, report *syncReport) error {
+	src, err := newSource(viper.GetViper(), []string{j.Source})
+	if err != nil {
+		return err
+	}
+	// the filters and prefix of the job apply to the paths mapped by its path rules
+	jobSrc := &jobSource{mapped(transformed(src, j.transforms), j.rules), j}
+	if isFile, err := syncToFile(cmd, jobSrc, j.Destination, report); isFile {
+		return err
+	}
+	dst, err := newDestination(j.Destination, runFlags.allowInsecure)
+	if err != nil {
+		return err
+	}
+	sync := newSyncer(dst, report)
+	written := &pathRecorder{sync, make(map[string]bool)}
+	if err := core.Walk(jobSrc, written); err != nil {
+		// an incomplete walk cannot tell which secrets were removed
+		if commitErr := commit(dst, j.Source, j.Destination); commitErr != nil {
+			return commitErr
+		}
+		return err
+	}
+	if j.Delete {
+		if err := deleteMissing(j, dst, written.paths, sync); err != nil {
+			if commitErr := commit(dst, j.Source, j.Destination); commitErr != nil {
+				return commitErr
+			}
+			return err
+		}
+	}
+	if err := commit(dst, j.Source, j.Destination); err != nil {
+		return err
+	}
+	return sync.err()
+}
+
+// deleteMissing deletes the destination secrets of a job missing from the
+// source. Destination paths whose source path the filters of the job leave
+// out are not the job's to delete.
+func deleteMissing(j *job, dst core.Endpoint, written map[string]bool, sync *syncer) error {
+	var existing core.SecretList
+	if err := core.Walk(dst, &existing); err != nil {
+		return err
+	}
+	var missing []core.Secret
+	for _, s := range existing {
+		if written[s.Path] || !backend.UnderPrefix(s.Path, dst.GetPath()) || !j.selected(j.unmapPath(s.Path)) {
+			continue
+		}
+		missing = append(missing, s)
+	}
+	if len(written) == 0 && len(missing) > 0 {
+		// an unreachable source looks empty, never delete everything because of it
+		return fmt.Errorf("the source returned no secrets, refusing to delete the %d secrets of the destination", len(missing))
+	}
+	for _, s := range missing {
+		start := time.Now()
+		err := dst.Delete(s)
+		if err != nil {
+			sync.failed++
+		}
+		sync.report.add(reportActionDelete, s.Path, s.Path, err, time.Since(start))
+		fmt.Fprintf(sync.out, "%s => deleted (%v)\n", s.Path, err)
+	}
+	return nil
+}
+
+// runScheduled runs the jobs with a schedule every interval until
+// interrupted, jobs without a schedule run once
+func runScheduled(cmd *cobra.Command, jobs []*job) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	next := make(map[*job]time.Time)
+	now := time.Now()
+	for _, j := range jobs {
+		next[j] = now
+	}
+	for len(next) > 0 {
+		var due time.Time
+		for _, j := range jobs {
+			at, ok := next[j]
+			if !ok {
+				continue
+			}
+			if !at.After(time.Now()) {
+				if err := runJob(cmd, j); err != nil {
+					// a failed job is retried on its next run
+					fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				}
+				if j.interval == 0 {
+					delete(next, j)
+					continue
+				}
+				at = time.Now().Add(j.interval)
+				next[j] = at
+			}
+			if due.IsZero() || at.Before(due) {
+				due = at
+			}
+		}
+		if due.IsZero() {
+			return
+		}
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "Stopping scheduled jobs on %v\n", sig)
+			return
+		case <-time.After(time.Until(due)):
+		}
+	}
+}
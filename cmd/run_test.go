@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+func TestJob_SelectedAndMapPath(t *testing.T) {
+	j := &job{Include: []string{"/secret/app/*"}, Exclude: []string{"/secret/app/tmp"}}
+	j.Prefix.From = "/secret/app/"
+	j.Prefix.To = "/secret/prod/app"
+	cases := map[string]bool{
+		"/secret/app/db":      true,
+		"/secret/app/db/user": true,
+		"/secret/app/tmp/x":   false,
+		"/secret/other/db":    false,
+		"/secret/application": false,
+	}
+	for p, expected := range cases {
+		if j.selected(p) != expected {
+			t.Fatalf("selected(%s) should be %v\n", p, expected)
+		}
+	}
+	if p := j.mapPath("/secret/app/db/user"); p != "/secret/prod/app/db/user" {
+		t.Fatalf("Unexpected mapped path: %s\n", p)
+	}
+	if p := j.mapPath("/secret/other"); p != "/secret/other" {
+		t.Fatalf("Expected paths outside the prefix to be unchanged: %s\n", p)
+	}
+	if p := j.unmapPath("/secret/prod/app/db/user"); p != "/secret/app/db/user" {
+		t.Fatalf("Unexpected unmapped path: %s\n", p)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/app/db", Value: "hunter2"})
+	src.Write(core.Secret{Path: "/secret/app/tmp", Value: "skip"})
+	dst.Write(core.Secret{Path: "/prod/app/stale", Value: "old"})
+	dst.Write(core.Secret{Path: "/other/keep", Value: "kept"})
+	dst.Write(core.Secret{Path: "/prod/app/tmp", Value: "excluded"})
+
+	v := viper.New()
+	v.Set("jobs", map[string]interface{}{
+		"promote": map[string]interface{}{
+			"source":      backend.DirScheme + srcRoot + "?path=/secret/app/",
+			"destination": backend.DirScheme + dstRoot + "?path=/prod/app/",
+			"exclude":     []string{"/secret/app/tmp"},
+			"prefix":      map[string]interface{}{"from": "/secret/app", "to": "/prod/app"},
+			"delete":      true,
+		},
+	})
+	jobs, err := loadJobs(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	runJob(nil, jobs["promote"])
+	os.Stdout = stdout
+
+	c := &collector{}
+	dst.Walk(c)
+	expected := []core.Secret{
+		{Path: "/other/keep", Value: "kept"},
+		{Path: "/prod/app/db", Value: "hunter2"},
+		{Path: "/prod/app/tmp", Value: "excluded"},
+	}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+}
+
+func TestLoadJobs_Invalid(t *testing.T) {
+	v := viper.New()
+	v.Set("jobs", map[string]interface{}{"broken": map[string]interface{}{"source": "vault://vault-a/secret/"}})
+	if _, err := loadJobs(v); err == nil {
+		t.Fatal("Expected an error for a job without a destination")
+	}
+	v.Set("jobs", map[string]interface{}{"broken": map[string]interface{}{"source": "a.json", "destination": "b.json", "schedule": "often"}})
+	if _, err := loadJobs(v); err == nil {
+		t.Fatal("Expected an error for an invalid schedule")
+	}
+	v.Set("jobs", map[string]interface{}{"broken": map[string]interface{}{"source": "a.json", "destination": "b.yaml", "delete": true}})
+	if _, err := loadJobs(v); err == nil {
+		t.Fatal("Expected an error for a job deleting from a file")
+	}
+}
+
+func TestDeleteMissing_EmptySource(t *testing.T) {
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	dst.Write(core.Secret{Path: "/prod/app/db", Value: "hunter2"})
+	j := &job{Name: "promote"}
+	sync := &syncer{out: ioutil.Discard, dst: dst}
+	if err := deleteMissing(j, dst, map[string]bool{}, sync); err == nil {
+		t.Fatal("Expected an empty source to delete nothing")
+	}
+	c := &collector{}
+	dst.Walk(c)
+	if len(c.secrets) != 1 {
+		t.Fatalf("Expected the destination to be kept: %v\n", c.secrets)
+	}
+}
+
+func TestRunJob_PathRules(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/app/legacy-db", Value: "hunter2"})
+	src.Write(core.Secret{Path: "/secret/app/tmp", Value: "skip"})
+
+	v := viper.New()
+	v.Set("jobs", map[string]interface{}{
+		"promote": map[string]interface{}{
+			"source":      backend.DirScheme + srcRoot + "?path=/secret/app/",
+			"destination": backend.DirScheme + dstRoot,
+			"exclude":     []string{"/secret/app/tmp"},
+			"path_rules":  []map[string]interface{}{{"pattern": "^/secret/app/legacy-", "replacement": "/secret/app/"}},
+		},
+	})
+	jobs, err := loadJobs(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	err = runJob(nil, jobs["promote"])
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &collector{}
+	dst.Walk(c)
+	expected := []core.Secret{{Path: "/secret/app/db", Value: "hunter2"}}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+	v.Set("jobs", map[string]interface{}{"broken": map[string]interface{}{"source": "a.json", "destination": "b.json", "path_rules": []map[string]interface{}{{"pattern": "("}}}})
+	if _, err := loadJobs(v); err == nil {
+		t.Fatal("Expected an error for an invalid path rule")
+	}
+}
@@ -1,12 +1,16 @@
 package cmd
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/drmdrew/syncrets/backend"
 	"github.com/drmdrew/syncrets/core"
@@ -14,17 +18,166 @@ import (
 	"github.com/spf13/viper"
 )
 
+var syncFlags struct {
+	ejsonKey      string
+	merge         bool
+	prune         bool
+	allowInsecure bool
+	jsonLayout    string
+	report        string
+	reportFile    string
+	watch         bool
+	interval      time.Duration
+	bidirectional bool
+	stateFile     string
+	prefer        string
+	transforms    []string
+	mapPaths      []string
+}
+
 func init() {
 	RootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().BoolVar(&syncFlags.merge, "merge", false, "merge into an existing .json or .ejson destination instead of replacing it")
+	syncCmd.Flags().BoolVar(&syncFlags.prune, "prune", false, "with --merge, delete secrets under the source path that are missing from the source")
+	syncCmd.Flags().BoolVar(&syncFlags.allowInsecure, "allow-insecure-output", false, "allow writing plaintext secrets into world-readable directories")
+	syncCmd.Flags().StringVar(&syncFlags.jsonLayout, "json-layout", backend.JSONLayoutNested, "layout of .json destinations: nested or flat")
+	syncCmd.Flags().StringVar(&syncFlags.report, "report", "text", "report format: text or json")
+	syncCmd.Flags().StringVar(&syncFlags.reportFile, "report-file", "", "write the json report to a file instead of stdout")
+	syncCmd.Flags().BoolVar(&syncFlags.watch, "watch", false, "keep syncing the changes of the source until interrupted")
+	syncCmd.Flags().DurationVar(&syncFlags.interval, "interval", 30*time.Second, "time between the passes of --watch")
+	syncCmd.Flags().BoolVar(&syncFlags.bidirectional, "bidirectional", false, "propagate the changes of either endpoint to the other")
+	syncCmd.Flags().StringVar(&syncFlags.stateFile, "state-file", "", "file keeping the fingerprints of the last --bidirectional sync")
+	syncCmd.Flags().StringVar(&syncFlags.prefer, "prefer", "", "resolve paths changed on both sides: source, destination or newer (KV version 2 only)")
+	syncCmd.Flags().StringArrayVar(&syncFlags.transforms, "transform", nil, "transform the secrets after the transforms of syncrets.yml, e.g. rename:from=value,to=password")
+	syncCmd.Flags().StringArrayVar(&syncFlags.mapPaths, "map-path", nil, "map the source paths matching a regex after the path_rules of syncrets.yml, e.g. '^/secret/([^/]+)/([^/]+)/=/secret/$2/$1/'")
+	syncCmd.Flags().StringVar(&syncFlags.ejsonKey, "ejson-key", "", "ejson public key, or name of one in ejson.keys, for .ejson destinations")
 }
 
-func createFileAndWriter(s string) (*os.File, *bufio.Writer) {
-	f, err := os.Create(s)
+// writeOutput atomically writes a destination file, checking the directory of plaintext files first
+func writeOutput(file string, plaintext bool, marshal func(io.Writer) error) error {
+	if plaintext {
+		if err := backend.CheckOutputDir(file, syncFlags.allowInsecure); err != nil {
+			return err
+		}
+	}
+	return backend.WriteFileAtomic(file, marshal)
+}
+
+// mergeFile reads an existing destination file into a merge, a missing file is left to be created
+func mergeFile(file string, merge func(io.Reader) error) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := merge(f); err != nil {
+		return fmt.Errorf("cannot merge into %s: %v", file, err)
+	}
+	return nil
+}
+
+// splitFileArg splits the query parameters from a file argument, e.g. out.ejson?public_key=team
+func splitFileArg(s string) (string, url.Values) {
+	i := strings.Index(s, "?")
+	if i < 0 {
+		return s, url.Values{}
+	}
+	query, err := url.ParseQuery(s[i+1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	w := bufio.NewWriter(f)
-	return f, w
+	return s[:i], query
+}
+
+func isYAMLFile(s string) bool {
+	return strings.HasSuffix(s, ".yaml") || strings.HasSuffix(s, ".yml")
+}
+
+// newSource returns a walker for a vault URL or a file that can be read back
+func newSource(v *viper.Viper, args []string) (core.Walker, error) {
+	if strings.HasPrefix(args[0], backend.K8sScheme) {
+		file, src, err := backend.ParseK8sURL(args[0])
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := src.Unmarshal(f); err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	if strings.HasPrefix(args[0], backend.SOPSScheme) {
+		keys, err := backend.NewSOPSKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		file, isJSON := backend.ParseSOPSURL(args[0])
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src := backend.NewSOPSEndpoint(keys, isJSON)
+		if err := src.Unmarshal(f); err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	if strings.HasSuffix(args[0], ".age") {
+		keys, err := backend.NewAgeKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src := backend.NewAgeEndpoint(keys)
+		if err := src.Unmarshal(f); err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	if strings.HasSuffix(args[0], ".json") {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src := backend.NewJSONEndpoint()
+		if err := src.Unmarshal(f); err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	if isYAMLFile(args[0]) {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		// a Secret manifest written by a k8s:// destination reads back as one
+		if backend.IsK8sManifest(b) {
+			src := backend.NewK8sEndpoint("")
+			if err := src.Unmarshal(bytes.NewReader(b)); err != nil {
+				return nil, err
+			}
+			return src, nil
+		}
+		src := backend.NewYAMLEndpoint()
+		if err := src.Unmarshal(bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	return backend.NewEndpoint(v, args)
 }
 
 var syncCmd = &cobra.Command{
@@ -33,42 +186,299 @@ var syncCmd = &cobra.Command{
 	Long:  `Sync secrets from vault`,
 	Run: func(cmd *cobra.Command, args []string) {
 		srcArgs := args[0:1]
-		src, err := backend.NewVaultBackend(viper.GetViper(), srcArgs)
+		src, err := newSource(viper.GetViper(), srcArgs)
 		if err != nil {
 			log.Fatal(err)
 		}
 		dstArgs := args[1:2]
-		if strings.HasSuffix(dstArgs[0], ".json") {
-			f, w := createFileAndWriter(dstArgs[0])
-			defer f.Close()
-			sync := backend.NewJSONEndpoint()
-			src.Walk(sync)
-			sync.Marshal(w)
-			w.Flush()
-		} else if strings.HasSuffix(dstArgs[0], ".ejson") {
-			f, w := createFileAndWriter(dstArgs[0])
-			defer f.Close()
-			sync := backend.NewEJSONEndpoint()
-			src.Walk(sync)
-			sync.Marshal(w)
-			w.Flush()
-		} else {
-			dst, err := backend.NewVaultBackend(viper.GetViper(), dstArgs)
-			if err != nil {
-				log.Fatal(err)
+		transforms := syncTransforms(viper.GetViper(), syncFlags.transforms)
+		rules := syncPathRules(viper.GetViper(), syncFlags.mapPaths)
+		if syncFlags.watch && syncFlags.bidirectional {
+			log.Fatal("--watch and --bidirectional cannot be used together")
+		}
+		if syncFlags.watch {
+			watchSync(cmd, src, transforms, rules, srcArgs[0], dstArgs[0])
+			return
+		}
+		report := newReport(srcArgs[0], dstArgs[0])
+		if syncFlags.bidirectional {
+			if len(transforms) > 0 || len(rules) > 0 {
+				log.Fatal("transforms and path rules cannot be reversed, they are not supported with --bidirectional")
 			}
-			sync := &syncer{os.Stdout, dst}
-			src.Walk(sync)
+			finishSync(report, syncBidirectional(src, srcArgs[0], dstArgs[0], report))
+			return
 		}
+		src = mapped(transformed(src, transforms), rules)
+		finishSync(report, syncOnce(cmd, src, srcArgs[0], dstArgs[0], report))
 	},
 }
 
+// syncOnce syncs src to a file or an endpoint
+func syncOnce(cmd *cobra.Command, src core.Walker, srcArg string, dstArg string, report *syncReport) error {
+	if isFile, err := syncToFile(cmd, src, dstArg, report); isFile {
+		return err
+	}
+	return syncToEndpoint(src, srcArg, dstArg, report)
+}
+
+// finishSync records a failed sync in the report and writes it before
+// exiting non-zero, so that there is a report exactly when one is needed
+func finishSync(report *syncReport, err error) {
+	report.fail(err)
+	writeReport(report)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isFileDestination reports whether dst is a file exported by syncToFile
+func isFileDestination(dst string) bool {
+	if strings.HasPrefix(dst, backend.K8sScheme) || strings.HasPrefix(dst, backend.SOPSScheme) {
+		return true
+	}
+	file, _ := splitFileArg(dst)
+	for _, ext := range []string{".json", ".ejson", ".age", ".yaml", ".yml", ".env"} {
+		if strings.HasSuffix(file, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncToFile exports the secrets of src to a file destination, returning
+// false if dst is not a file. The file is only written after a complete walk.
+func syncToFile(cmd *cobra.Command, src core.Walker, dst string, report *syncReport) (bool, error) {
+	if !isFileDestination(dst) {
+		return false, nil
+	}
+	export, err := newFileExport(cmd, src, dst)
+	if err != nil {
+		return true, err
+	}
+	if err := core.Walk(src, report.visitor(export.visitor, dst)); err != nil {
+		return true, err
+	}
+	return true, export.write()
+}
+
+// fileExport is a file destination, written once all the secrets were visited
+type fileExport struct {
+	visitor core.Visitor
+	write   func() error
+}
+
+// newFileExport returns the export of src to the file destination dst
+func newFileExport(cmd *cobra.Command, src core.Walker, dst string) (*fileExport, error) {
+	if strings.HasPrefix(dst, backend.K8sScheme) {
+		file, sync, err := backend.ParseK8sURL(dst)
+		if err != nil {
+			return nil, err
+		}
+		if sync.Prefix == "" {
+			sync.Prefix = sourcePrefix(src)
+		}
+		return &fileExport{sync, func() error {
+			// check for key collisions before touching the destination file
+			if _, err := sync.Data(); err != nil {
+				return err
+			}
+			return writeOutput(file, true, sync.Marshal)
+		}}, nil
+	}
+	if strings.HasPrefix(dst, backend.SOPSScheme) {
+		keys, err := backend.NewSOPSKeys(viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		file, isJSON := backend.ParseSOPSURL(dst)
+		sync := backend.NewSOPSEndpoint(keys, isJSON)
+		return &fileExport{sync, func() error {
+			return writeOutput(file, false, sync.Marshal)
+		}}, nil
+	}
+	if strings.HasSuffix(dst, ".json") {
+		sync := backend.NewJSONEndpoint()
+		switch syncFlags.jsonLayout {
+		case backend.JSONLayoutNested, backend.JSONLayoutFlat:
+			sync.Layout = syncFlags.jsonLayout
+		default:
+			return nil, fmt.Errorf("unknown --json-layout '%s', expected nested or flat", syncFlags.jsonLayout)
+		}
+		return &fileExport{sync, func() error {
+			if syncFlags.merge {
+				err := mergeFile(dst, func(in io.Reader) error {
+					return sync.Merge(in, sourcePrefix(src), syncFlags.prune)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return writeOutput(dst, true, sync.Marshal)
+		}}, nil
+	}
+	if file, query := splitFileArg(dst); strings.HasSuffix(file, ".ejson") {
+		selector := syncFlags.ejsonKey
+		if selector == "" {
+			selector = query.Get("public_key")
+		}
+		publicKey, err := backend.SelectEJSONPublicKey(viper.GetViper(), selector, file)
+		if err != nil {
+			return nil, err
+		}
+		sync := backend.NewEJSONEndpoint(publicKey)
+		return &fileExport{sync, func() error {
+			if syncFlags.merge {
+				err := mergeFile(file, func(in io.Reader) error {
+					return sync.Merge(in, sourcePrefix(src), syncFlags.prune)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return writeOutput(file, false, sync.Marshal)
+		}}, nil
+	}
+	if strings.HasSuffix(dst, ".age") {
+		keys, err := backend.NewAgeKeys(viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		if len(keys.Recipients) == 0 {
+			return nil, errors.New("no age.recipients configured")
+		}
+		sync := backend.NewAgeEndpoint(keys)
+		return &fileExport{sync, func() error {
+			return writeOutput(dst, false, sync.Marshal)
+		}}, nil
+	}
+	if isYAMLFile(dst) {
+		sync := backend.NewYAMLEndpoint()
+		return &fileExport{sync, func() error {
+			return writeOutput(dst, true, sync.Marshal)
+		}}, nil
+	}
+	names := newEnvNameMapper(cmd, viper.GetViper(), src)
+	sync := backend.NewEnvEndpoint(names, backend.EnvFormatDotenv)
+	return &fileExport{sync, func() error {
+		// check for name collisions before touching the destination file
+		if _, err := sync.Vars(); err != nil {
+			return err
+		}
+		return writeOutput(dst, true, sync.Marshal)
+	}}, nil
+}
+
+// newDestination opens the endpoint of a destination, allowInsecure lets
+// dir:// destinations write into world-readable directories
+func newDestination(arg string, allowInsecure bool) (core.Endpoint, error) {
+	dst, err := backend.NewEndpoint(viper.GetViper(), []string{arg})
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := dst.(*backend.Dir); ok {
+		d.AllowInsecure = allowInsecure
+	}
+	return dst, nil
+}
+
+// syncToEndpoint writes the secrets of src to an endpoint, committing them if the endpoint batches changes
+func syncToEndpoint(src core.Walker, srcArg string, dstArg string, report *syncReport) error {
+	dst, err := newDestination(dstArg, syncFlags.allowInsecure)
+	if err != nil {
+		return err
+	}
+	return syncWalk(src, dst, srcArg, dstArg, report)
+}
+
+// syncWalk writes the secrets of src to dst, failing if the walk was
+// incomplete or any secret could not be written
+func syncWalk(src core.Walker, dst core.Endpoint, srcArg string, dstArg string, report *syncReport) error {
+	sync := newSyncer(dst, report)
+	walkErr := core.Walk(src, sync)
+	if err := commit(dst, srcArg, dstArg); err != nil {
+		return err
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+	return sync.err()
+}
+
+// syncOutput is where results are printed, unless the report goes to stdout
+func syncOutput(report *syncReport) io.Writer {
+	if report != nil && syncFlags.reportFile == "" {
+		// the report replaces the text output
+		return ioutil.Discard
+	}
+	return os.Stdout
+}
+
+// newSyncer returns a syncer writing to dst
+func newSyncer(dst core.Endpoint, report *syncReport) *syncer {
+	return &syncer{out: syncOutput(report), dst: dst, report: report}
+}
+
+// commit the changes of endpoints batching them up
+func commit(dst core.Endpoint, srcArg string, dstArg string) error {
+	if committer, ok := dst.(core.Committer); ok {
+		summary := fmt.Sprintf("syncrets sync %s %s", srcArg, dstArg)
+		return committer.Commit(summary)
+	}
+	return nil
+}
+
+// newReport returns the report selected by --report and --report-file, or nil for text output
+func newReport(srcArg string, dstArg string) *syncReport {
+	switch syncFlags.report {
+	case "json":
+		return newSyncReport(srcArg, dstArg)
+	case "text":
+		if syncFlags.reportFile != "" {
+			return newSyncReport(srcArg, dstArg)
+		}
+		return nil
+	}
+	log.Fatalf("unknown --report '%s', expected text or json", syncFlags.report)
+	return nil
+}
+
+// writeReport writes the report of a sync to --report-file or stdout
+func writeReport(report *syncReport) {
+	if report == nil {
+		return
+	}
+	var err error
+	if syncFlags.reportFile != "" {
+		err = backend.WriteFileAtomic(syncFlags.reportFile, report.write)
+	} else {
+		err = report.write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 type syncer struct {
-	out io.Writer
-	dst core.Endpoint
+	out    io.Writer
+	dst    core.Endpoint
+	report *syncReport
+	failed int
 }
 
 func (sync *syncer) Visit(s core.Secret) {
+	start := time.Now()
 	err := sync.dst.Write(s)
-	fmt.Fprintf(sync.out, "%s => %s (%v)\n", s.Path, s.Path, err)
+	if err != nil {
+		sync.failed++
+	}
+	sync.report.add(reportActionWrite, s.SourcePath(), s.Path, err, time.Since(start))
+	fmt.Fprintf(sync.out, "%s => %s (%v)\n", s.SourcePath(), s.Path, err)
+}
+
+// err returns an error if any secret could not be written
+func (sync *syncer) err() error {
+	if sync.failed > 0 {
+		return fmt.Errorf("%d secrets could not be written", sync.failed)
+	}
+	return nil
 }
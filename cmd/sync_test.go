@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+)
+
+func newTestDir(t *testing.T) (*backend.Dir, string) {
+	root, err := ioutil.TempDir("", "syncrets-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := backend.NewDirBackend([]string{backend.DirScheme + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, root
+}
+
+type collector struct {
+	secrets []core.Secret
+}
+
+func (c *collector) Visit(s core.Secret) {
+	c.secrets = append(c.secrets, s)
+}
+
+func TestSyncer_Dir(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/foo", Value: "bar"})
+	src.Write(core.Secret{Path: "/secret/foo/bar", Value: "foobar"})
+
+	out := new(bytes.Buffer)
+	src.Walk(&syncer{out: out, dst: dst})
+	expected := "/secret/foo => /secret/foo (<nil>)\n/secret/foo/bar => /secret/foo/bar (<nil>)\n"
+	if out.String() != expected {
+		t.Fatalf("Expected: '%s' but result was: '%s'\n", expected, out.String())
+	}
+	c := &collector{}
+	dst.Walk(c)
+	if len(c.secrets) != 2 || c.secrets[1].Value != "foobar" {
+		t.Fatalf("Unexpected destination secrets: %v\n", c.secrets)
+	}
+}
+
+func TestNewSource_K8sManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncrets-k8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k := backend.NewK8sEndpoint("app")
+	k.Prefix = "/secret/app/"
+	secrets := []core.Secret{
+		{Path: "/secret/app/db", Fields: map[string]string{"user": "admin", "password": "hunter2"}},
+		{Path: "/secret/app/values", Value: "plain"},
+	}
+	for _, s := range secrets {
+		k.Visit(s)
+	}
+	file := dir + "/app-secret.yaml"
+	if err := backend.WriteFileAtomic(file, k.Marshal); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newSource(nil, []string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	src.Walk(c)
+	if !reflect.DeepEqual(c.secrets, secrets) {
+		t.Fatalf("Expected: %v but result was: %v\n", secrets, c.secrets)
+	}
+
+	// the name of a file holding plain YAML does not make it a manifest
+	ioutil.WriteFile(file, []byte("db:\n  password: hunter2\n"), 0600)
+	if src, err = newSource(nil, []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	c = &collector{}
+	src.Walk(c)
+	if len(c.secrets) != 1 || c.secrets[0].Path != "/db/password" {
+		t.Fatalf("Expected the YAML tree: %v\n", c.secrets)
+	}
+}
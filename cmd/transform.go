@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// TransformFactory returns a transformer for the settings of a transform
+// configured in syncrets.yml or with --transform
+type TransformFactory func(settings map[string]string) (core.Transformer, error)
+
+var transformTypes = map[string]TransformFactory{
+	"rename": func(settings map[string]string) (core.Transformer, error) {
+		if settings["from"] == "" || settings["to"] == "" {
+			return nil, fmt.Errorf("rename needs from and to")
+		}
+		return core.RenameField{From: settings["from"], To: settings["to"]}, nil
+	},
+	"drop": func(settings map[string]string) (core.Transformer, error) {
+		if settings["field"] == "" {
+			return nil, fmt.Errorf("drop needs a field")
+		}
+		return core.DropField{Field: settings["field"]}, nil
+	},
+	"base64-encode": func(settings map[string]string) (core.Transformer, error) {
+		return core.Base64Encode{Field: settings["field"]}, nil
+	},
+	"base64-decode": func(settings map[string]string) (core.Transformer, error) {
+		return core.Base64Decode{Field: settings["field"]}, nil
+	},
+	"replace": func(settings map[string]string) (core.Transformer, error) {
+		if settings["pattern"] == "" {
+			return nil, fmt.Errorf("replace needs a pattern")
+		}
+		pattern, err := regexp.Compile(settings["pattern"])
+		if err != nil {
+			return nil, err
+		}
+		return core.ReplaceValue{Field: settings["field"], Pattern: pattern, Replacement: settings["replacement"]}, nil
+	},
+}
+
+// RegisterTransform adds a transform type, for programs embedding the syncrets commands
+func RegisterTransform(name string, factory TransformFactory) {
+	transformTypes[name] = factory
+}
+
+// newTransforms returns the transformers for a list of settings, each naming its type
+func newTransforms(configs []map[string]string) (core.Transforms, error) {
+	var transforms core.Transforms
+	for i, settings := range configs {
+		factory, ok := transformTypes[settings["type"]]
+		if !ok {
+			names := make([]string, 0, len(transformTypes))
+			for name := range transformTypes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("transform %d has an unknown type '%s', expected one of %s", i+1, settings["type"], strings.Join(names, ", "))
+		}
+		transformer, err := factory(settings)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %v", i+1, err)
+		}
+		transforms = append(transforms, transformer)
+	}
+	return transforms, nil
+}
+
+// parseTransformFlag parses --transform TYPE[:KEY=VALUE,...], e.g. rename:from=value,to=password
+func parseTransformFlag(s string) (map[string]string, error) {
+	settings := make(map[string]string)
+	i := strings.Index(s, ":")
+	if i < 0 {
+		settings["type"] = s
+		return settings, nil
+	}
+	settings["type"] = s[:i]
+	for _, setting := range strings.Split(s[i+1:], ",") {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --transform '%s', expected TYPE:KEY=VALUE,...", s)
+		}
+		settings[kv[0]] = kv[1]
+	}
+	return settings, nil
+}
+
+// syncTransforms returns the transforms section of syncrets.yml followed by the --transform flags
+func syncTransforms(v *viper.Viper, flags []string) core.Transforms {
+	var configs []map[string]string
+	if err := v.UnmarshalKey("transforms", &configs); err != nil {
+		log.Fatalf("invalid transforms in syncrets.yml: %v", err)
+	}
+	for _, flag := range flags {
+		settings, err := parseTransformFlag(flag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configs = append(configs, settings)
+	}
+	transforms, err := newTransforms(configs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return transforms
+}
+
+// transformed returns a walker transforming the secrets of src, or src itself without transforms
+func transformed(src core.Walker, transforms core.Transforms) core.Walker {
+	if len(transforms) == 0 {
+		return src
+	}
+	return &transformSource{src, transforms}
+}
+
+// transformSource walks a source, visiting the transformed secrets
+type transformSource struct {
+	src       core.Walker
+	transform core.Transformer
+}
+
+// GetPath is the prefix of the source
+func (t *transformSource) GetPath() string {
+	return sourcePrefix(t.src)
+}
+
+// Walk ...
+func (t *transformSource) Walk(visitor core.Visitor) {
+	t.src.Walk(&transformVisitor{t.transform, visitor})
+}
+
+type transformVisitor struct {
+	transform core.Transformer
+	visitor   core.Visitor
+}
+
+// Visit reports a failed transform as an error of the walk rather than
+// visiting a half transformed secret
+func (v *transformVisitor) Visit(s core.Secret) {
+	s, err := v.transform.Transform(s)
+	if err != nil {
+		core.ReportError(v.visitor, s.Path, fmt.Errorf("cannot transform: %v", err))
+		return
+	}
+	v.visitor.Visit(s)
+}
+
+// VisitError passes on the errors of the walk
+func (v *transformVisitor) VisitError(path string, err error) {
+	core.ReportError(v.visitor, path, err)
+}
+
+// Unchanged lets the visitor skip secrets it already holds, transforms keep the path
+func (v *transformVisitor) Unchanged(path string, version int) bool {
+	if skipper, ok := v.visitor.(core.VersionSkipper); ok {
+		return skipper.Unchanged(path, version)
+	}
+	return false
+}
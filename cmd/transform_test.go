@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/viper"
+)
+
+// secretWalker walks a list of secrets with several fields
+type secretWalker []core.Secret
+
+func (w secretWalker) Walk(visitor core.Visitor) {
+	for _, s := range w {
+		visitor.Visit(s)
+	}
+}
+
+func TestSyncTransforms(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	config := `
+transforms:
+  - type: replace
+    field: url
+    pattern: '\.dev\.'
+    replacement: .prod.
+`
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	transforms := syncTransforms(v, []string{"rename:from=value,to=password", "drop:field=tmp"})
+	if len(transforms) != 3 {
+		t.Fatalf("Expected the config and flag transforms: %v\n", transforms)
+	}
+
+	src := secretWalker{{Path: "/secret/db", Value: "hunter2", Fields: map[string]string{"url": "db.dev.example.com", "tmp": "x"}}}
+	c := &collector{}
+	transformed(src, transforms).Walk(c)
+	expected := []core.Secret{{Path: "/secret/db", Fields: map[string]string{"password": "hunter2", "url": "db.prod.example.com"}}}
+	if len(c.secrets) != 1 || !reflect.DeepEqual(c.secrets[0].Fields, expected[0].Fields) || c.secrets[0].Value != "" {
+		t.Fatalf("Expected: %v but result was: %v\n", expected, c.secrets)
+	}
+	if _, ok := transformed(src, nil).(secretWalker); !ok {
+		t.Fatal("Expected the source itself without transforms")
+	}
+}
+
+func TestNewTransforms_Errors(t *testing.T) {
+	for _, flag := range []string{"unknown", "rename:from=value", "replace:pattern=(", "drop"} {
+		settings, err := parseTransformFlag(flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newTransforms([]map[string]string{settings}); err == nil {
+			t.Fatalf("Expected an error for --transform %s\n", flag)
+		}
+	}
+	if _, err := parseTransformFlag("rename:from"); err == nil {
+		t.Fatal("Expected an error for a setting without a value")
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	RegisterTransform("upper", func(settings map[string]string) (core.Transformer, error) {
+		return core.TransformFunc(func(s core.Secret) (core.Secret, error) {
+			s.Value = strings.ToUpper(s.Value)
+			return s, nil
+		}), nil
+	})
+	defer delete(transformTypes, "upper")
+	transforms, err := newTransforms([]map[string]string{{"type": "upper"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := transforms.Transform(core.Secret{Path: "/a", Value: "abc"})
+	if s.Value != "ABC" {
+		t.Fatalf("Unexpected value: %s\n", s.Value)
+	}
+}
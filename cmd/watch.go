@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/drmdrew/syncrets/core"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// fingerprint hashes the path and all the fields of a secret
+func fingerprint(s core.Secret) string {
+	return hashSecret(sha256.New(), s)
+}
+
+// hashSecret writes the path and the sorted fields of a secret to h
+func hashSecret(h hash.Hash, s core.Secret) string {
+	data := s.Data()
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(h, "%s\x00", s.Path)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%v\x00", name, data[name])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+type watchEntry struct {
+	secret      core.Secret
+	fingerprint string
+}
+
+// watcher remembers the secrets of a source between passes, collecting the
+// secrets that changed or were removed since the previous pass
+type watcher struct {
+	known   map[string]watchEntry
+	seen    map[string]bool
+	changed []core.Secret
+	path    string
+	// failures counts the passes that could not be fully applied
+	failures int
+	// dirty is set when a file destination could not be written
+	dirty bool
+}
+
+func newWatcher() *watcher {
+	return &watcher{known: make(map[string]watchEntry)}
+}
+
+// Visit ...
+func (w *watcher) Visit(s core.Secret) {
+	w.seen[s.Path] = true
+	fp := fingerprint(s)
+	if entry, ok := w.known[s.Path]; ok && entry.fingerprint == fp {
+		return
+	}
+	w.known[s.Path] = watchEntry{s, fp}
+	w.changed = append(w.changed, s)
+}
+
+// Unchanged skips reading KV version 2 secrets whose version is known
+func (w *watcher) Unchanged(path string, version int) bool {
+	entry, ok := w.known[path]
+	if !ok || version == 0 || entry.secret.Metadata.Version != version {
+		return false
+	}
+	w.seen[path] = true
+	return true
+}
+
+// pass walks src, returning the changed and removed secrets. Nothing is
+// removed after an incomplete walk, the secrets it missed may still exist.
+func (w *watcher) pass(src core.Walker) ([]core.Secret, []core.Secret, error) {
+	w.seen = make(map[string]bool)
+	w.changed = nil
+	if err := core.Walk(src, w); err != nil {
+		return w.changed, nil, err
+	}
+	if len(w.seen) == 0 && len(w.known) > 0 {
+		// an unreachable source looks empty, never remove everything because of it
+		log.Printf("Source returned no secrets, keeping the %d known secrets\n", len(w.known))
+		return w.changed, nil, nil
+	}
+	var removed []core.Secret
+	for path, entry := range w.known {
+		if !w.seen[path] {
+			removed = append(removed, entry.secret)
+			delete(w.known, path)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Path < removed[j].Path })
+	return w.changed, removed, nil
+}
+
+// GetPath is the prefix of the source, for destinations that use it
+func (w *watcher) GetPath() string {
+	return w.path
+}
+
+// Walk the known secrets, as a source for file destinations
+func (w *watcher) Walk(visitor core.Visitor) {
+	paths := make([]string, 0, len(w.known))
+	for path := range w.known {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		visitor.Visit(w.known[path].secret)
+	}
+}
+
+// renew the sessions of endpoints that expire
+func renew(walkers ...interface{}) {
+	for _, w := range walkers {
+		if r, ok := w.(core.Renewer); ok {
+			if err := r.Renew(); err != nil {
+				log.Printf("ERROR: renew failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// watchSync syncs src to dst every --interval until interrupted, applying
+// only the secrets that changed or were removed since the previous pass
+func watchSync(cmd *cobra.Command, src core.Walker, transforms core.Transforms, rules core.PathRules, srcArg string, dstArg string) {
+	if syncFlags.interval <= 0 {
+		log.Fatalf("invalid --interval %v", syncFlags.interval)
+	}
+	w := newWatcher()
+	w.path = sourcePrefix(src)
+	var dst core.Endpoint
+	if !isFileDestination(dstArg) {
+		var err error
+		if dst, err = newDestination(dstArg, syncFlags.allowInsecure); err != nil {
+			log.Fatal(err)
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(syncFlags.interval)
+	defer ticker.Stop()
+	for {
+		if _, isEndpoint := src.(core.Endpoint); !isEndpoint {
+			// files are read when the source is created
+			var err error
+			if src, err = newSource(viper.GetViper(), []string{srcArg}); err != nil {
+				log.Printf("ERROR: %v\n", err)
+			}
+		}
+		if src != nil {
+			renew(src, dst)
+			watchPass(cmd, w, mapped(transformed(src, transforms), rules), srcArg, dstArg, dst)
+		}
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "Stopping watch on %v\n", sig)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchPass applies the changes found by one pass of the watcher. Errors
+// are logged and counted, and what failed is retried on the next pass.
+func watchPass(cmd *cobra.Command, w *watcher, src core.Walker, srcArg string, dstArg string, dst core.Endpoint) {
+	changed, removed, walkErr := w.pass(src)
+	if walkErr != nil {
+		log.Printf("ERROR: %v, not deleting anything in this pass\n", walkErr)
+	}
+	if len(changed) == 0 && len(removed) == 0 && !w.dirty {
+		log.Printf("No changes in %s\n", srcArg)
+		w.fail(walkErr, nil)
+		return
+	}
+	report := newReport(srcArg, dstArg)
+	var err error
+	if dst == nil {
+		// files are rewritten with all the known secrets
+		_, err = syncToFile(cmd, w, dstArg, report)
+		w.dirty = err != nil
+	} else {
+		err = w.apply(dst, changed, removed, srcArg, dstArg, report)
+	}
+	report.fail(err)
+	writeReport(report)
+	w.fail(walkErr, err)
+}
+
+// apply writes the changed secrets to dst and deletes the removed ones,
+// forgetting what failed so that the next pass tries again
+func (w *watcher) apply(dst core.Endpoint, changed []core.Secret, removed []core.Secret, srcArg string, dstArg string, report *syncReport) error {
+	sync := newSyncer(dst, report)
+	var retry []core.Secret
+	for _, s := range changed {
+		failed := sync.failed
+		sync.Visit(s)
+		if sync.failed > failed {
+			delete(w.known, s.Path)
+		}
+	}
+	for _, s := range removed {
+		start := time.Now()
+		err := dst.Delete(s)
+		report.add(reportActionDelete, s.SourcePath(), s.Path, err, time.Since(start))
+		fmt.Fprintf(sync.out, "%s => deleted (%v)\n", s.Path, err)
+		if err != nil {
+			sync.failed++
+			retry = append(retry, s)
+		}
+	}
+	err := commit(dst, srcArg, dstArg)
+	if err != nil {
+		// nothing of this pass was committed, apply all of it again
+		for _, s := range changed {
+			delete(w.known, s.Path)
+		}
+		retry = removed
+	}
+	for _, s := range retry {
+		w.known[s.Path] = watchEntry{s, fingerprint(s)}
+	}
+	if err != nil {
+		return err
+	}
+	return sync.err()
+}
+
+// fail logs the error of a pass and counts the passes that failed, the
+// error of the walk was already logged by watchPass
+func (w *watcher) fail(walkErr error, err error) {
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+	}
+	if walkErr == nil && err == nil {
+		return
+	}
+	w.failures++
+	log.Printf("%d passes failed so far, retrying on the next pass\n", w.failures)
+}
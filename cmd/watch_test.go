@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/drmdrew/syncrets/backend"
+	"github.com/drmdrew/syncrets/core"
+)
+
+func paths(secrets []core.Secret) []string {
+	result := []string{}
+	for _, s := range secrets {
+		result = append(result, s.Path)
+	}
+	return result
+}
+
+func TestWatcher_Pass(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	dst, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	src.Write(core.Secret{Path: "/secret/a", Value: "1"})
+	src.Write(core.Secret{Path: "/secret/b", Value: "2"})
+	src.Write(core.Secret{Path: "/secret/c", Value: "3"})
+
+	w := newWatcher()
+	changed, removed, _ := w.pass(src)
+	if !reflect.DeepEqual(paths(changed), []string{"/secret/a", "/secret/b", "/secret/c"}) || len(removed) != 0 {
+		t.Fatalf("Unexpected first pass: %v %v\n", changed, removed)
+	}
+	changed, removed, _ = w.pass(src)
+	if len(changed) != 0 || len(removed) != 0 {
+		t.Fatalf("Expected no changes: %v %v\n", changed, removed)
+	}
+
+	src.Write(core.Secret{Path: "/secret/b", Value: "two"})
+	src.Delete(core.Secret{Path: "/secret/c"})
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	watchPass(nil, w, src, "src", "dst", dst)
+	os.Stdout = stdout
+	c := &collector{}
+	dst.Walk(c)
+	expected := []core.Secret{{Path: "/secret/b", Value: "two"}}
+	if !reflect.DeepEqual(c.secrets, expected) {
+		t.Fatalf("Expected only the change to be applied: %v\n", c.secrets)
+	}
+}
+
+func TestWatcher_EmptySourceKeepsSecrets(t *testing.T) {
+	src, srcRoot := newTestDir(t)
+	defer os.RemoveAll(srcRoot)
+	src.Write(core.Secret{Path: "/secret/a", Value: "1"})
+	w := newWatcher()
+	w.pass(src)
+	src.Delete(core.Secret{Path: "/secret/a"})
+	if _, removed, _ := w.pass(src); len(removed) != 0 {
+		t.Fatalf("Expected an empty source to remove nothing: %v\n", removed)
+	}
+}
+
+// failingWalker walks its secrets but fails to list a prefix
+type failingWalker struct {
+	secretWalker
+	failed string
+}
+
+func (f *failingWalker) Walk(visitor core.Visitor) {
+	f.secretWalker.Walk(visitor)
+	if f.failed != "" {
+		core.ReportError(visitor, f.failed, errors.New("permission denied"))
+	}
+}
+
+func TestWatcher_IncompleteWalkRemovesNothing(t *testing.T) {
+	src := &failingWalker{secretWalker: secretWalker{{Path: "/secret/a", Value: "1"}, {Path: "/secret/sub/b", Value: "2"}}}
+	w := newWatcher()
+	w.pass(src)
+	// the sub-prefix fails to list and b is missing from the walk
+	src.secretWalker = secretWalker{{Path: "/secret/a", Value: "one"}}
+	src.failed = "/secret/sub/"
+	changed, removed, err := w.pass(src)
+	if err == nil || len(removed) != 0 || !reflect.DeepEqual(paths(changed), []string{"/secret/a"}) {
+		t.Fatalf("Expected the change without removals: %v %v %v\n", changed, removed, err)
+	}
+	src.failed = ""
+	if _, removed, err := w.pass(src); err != nil || !reflect.DeepEqual(paths(removed), []string{"/secret/sub/b"}) {
+		t.Fatalf("Expected b to be removed after a complete walk: %v %v\n", removed, err)
+	}
+}
+
+func TestWatcher_Unchanged(t *testing.T) {
+	w := newWatcher()
+	w.seen = make(map[string]bool)
+	w.Visit(core.Secret{Path: "/secret/data/a", Value: "1", Metadata: core.Metadata{Version: 2}})
+	if !w.Unchanged("/secret/data/a", 2) || w.Unchanged("/secret/data/a", 3) || w.Unchanged("/secret/data/b", 1) {
+		t.Fatal("Expected only the known version to be unchanged")
+	}
+	if fingerprint(core.Secret{Path: "/a", Value: "1"}) == fingerprint(core.Secret{Path: "/a", Value: "2"}) {
+		t.Fatal("Expected different fingerprints for different values")
+	}
+}
+
+// failingEndpoint is a directory whose writes and deletes fail while fail is set
+type failingEndpoint struct {
+	*backend.Dir
+	fail bool
+}
+
+func (f *failingEndpoint) Write(s core.Secret) error {
+	if f.fail {
+		return errors.New("connection refused")
+	}
+	return f.Dir.Write(s)
+}
+
+func (f *failingEndpoint) Delete(s core.Secret) error {
+	if f.fail {
+		return errors.New("connection refused")
+	}
+	return f.Dir.Delete(s)
+}
+
+func TestWatcher_RetryFailedPass(t *testing.T) {
+	d, dstRoot := newTestDir(t)
+	defer os.RemoveAll(dstRoot)
+	dst := &failingEndpoint{Dir: d, fail: true}
+	src := secretWalker{{Path: "/secret/a", Value: "1"}}
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	w := newWatcher()
+	watchPass(nil, w, src, "src", "dst", dst)
+	if w.failures != 1 {
+		t.Fatalf("Expected the failed pass to be counted: %d\n", w.failures)
+	}
+	dst.fail = false
+	watchPass(nil, w, src, "src", "dst", dst)
+	c := &collector{}
+	dst.Walk(c)
+	if !reflect.DeepEqual(paths(c.secrets), []string{"/secret/a"}) || w.failures != 1 {
+		t.Fatalf("Expected the failed write to be retried: %v %d\n", c.secrets, w.failures)
+	}
+
+	// a failed delete is retried too
+	dst.fail = true
+	watchPass(nil, w, secretWalker{{Path: "/secret/b", Value: "2"}}, "src", "dst", dst)
+	dst.fail = false
+	watchPass(nil, w, secretWalker{{Path: "/secret/b", Value: "2"}}, "src", "dst", dst)
+	c = &collector{}
+	dst.Walk(c)
+	if !reflect.DeepEqual(paths(c.secrets), []string{"/secret/b"}) || w.failures != 2 {
+		t.Fatalf("Expected the failed delete to be retried: %v %d\n", c.secrets, w.failures)
+	}
+}
@@ -19,9 +19,14 @@ func ParseURL(s string) *url.URL {
 	return u
 }
 
-// ResolveAlias to resolve an alias
+// ResolveAlias to resolve a vault alias
 func ResolveAlias(v *viper.Viper, alias string) *url.URL {
-	vkey := fmt.Sprintf("vault.%s.url", alias)
+	return ResolveSectionAlias(v, "vault", alias)
+}
+
+// ResolveSectionAlias to resolve an alias in a section of the config, e.g. consul
+func ResolveSectionAlias(v *viper.Viper, section string, alias string) *url.URL {
+	vkey := fmt.Sprintf("%s.%s.url", section, alias)
 	vurl := v.GetString(vkey)
 	log.Printf("Checking for alias: %v", vkey)
 	if vurl != "" {
@@ -31,12 +36,17 @@ func ResolveAlias(v *viper.Viper, alias string) *url.URL {
 	return nil
 }
 
-// ReverseLookupAlias from a URL
+// ReverseLookupAlias from a vault URL
 func ReverseLookupAlias(v *viper.Viper, u *url.URL) string {
+	return ReverseLookupSectionAlias(v, "vault", u)
+}
+
+// ReverseLookupSectionAlias from a URL in a section of the config
+func ReverseLookupSectionAlias(v *viper.Viper, section string, u *url.URL) string {
 	urlMap := make(map[string]string, 1)
-	aliases := v.GetStringMap("vault")
+	aliases := v.GetStringMap(section)
 	for alias := range aliases {
-		vkey := fmt.Sprintf("vault.%s.url", alias)
+		vkey := fmt.Sprintf("%s.%s.url", section, alias)
 		aliasURL := v.GetString(vkey)
 		urlMap[aliasURL] = alias
 	}
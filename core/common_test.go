@@ -33,3 +33,11 @@ func TestReverseLookupAlias_Valid(t *testing.T) {
 		assert.Equal(t, tc.expect, alias)
 	}
 }
+
+func TestReverseLookupSectionAlias_Consul(t *testing.T) {
+	v := getViper("../testdata/syncrets-test1.yml")
+	u, _ := url.Parse("http://localhost:8500")
+	assert.Equal(t, "consul-a", ReverseLookupSectionAlias(v, "consul", u))
+	assert.Equal(t, "", ReverseLookupSectionAlias(v, "vault", u))
+	assert.Equal(t, "http://localhost:8500", ResolveSectionAlias(v, "consul", "consul-a").String())
+}
@@ -14,3 +14,20 @@ type Endpoint interface {
 	Write(secret Secret) error
 	Delete(secret Secret) error
 }
+
+// Reader is implemented by endpoints that can read a single secret
+type Reader interface {
+	// Read returns the secret at path, or nil if there is none
+	Read(path string) (*Secret, error)
+}
+
+// Committer is implemented by endpoints that batch up writes and deletes
+// until they are committed, e.g. as a single commit in a git repository
+type Committer interface {
+	Commit(summary string) error
+}
+
+// Renewer is implemented by endpoints whose session expires, e.g. vault tokens
+type Renewer interface {
+	Renew() error
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"regexp"
+)
+
+// PathRule maps the paths matching Pattern to Replacement, which can refer to
+// the groups of Pattern as $1 or ${name}
+type PathRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// PathRules maps a path with the first rule matching it
+type PathRules []PathRule
+
+// Map returns the mapped path, paths matching no rule are unchanged
+func (rules PathRules) Map(path string) string {
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(path) {
+			return rule.Pattern.ReplaceAllString(path, rule.Replacement)
+		}
+	}
+	return path
+}
+
+// Transform maps the path of a secret, see Map
+func (rules PathRules) Transform(secret Secret) (Secret, error) {
+	secret.Path = rules.Map(secret.Path)
+	return secret, nil
+}
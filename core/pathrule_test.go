@@ -0,0 +1,21 @@
+package core
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathRules_Map(t *testing.T) {
+	rules := PathRules{
+		{Pattern: regexp.MustCompile(`^/secret/(?P<app>[^/]+)/(?P<env>prod|dev)/`), Replacement: "/secret/${env}/${app}/"},
+		{Pattern: regexp.MustCompile(`^/secret/(.*)$`), Replacement: "/legacy/$1"},
+	}
+	assert.Equal(t, "/secret/prod/web/db", rules.Map("/secret/web/prod/db"))
+	assert.Equal(t, "/legacy/web/qa/db", rules.Map("/secret/web/qa/db"))
+	assert.Equal(t, "/other/db", rules.Map("/other/db"))
+	s, err := rules.Transform(Secret{Path: "/secret/web/dev/db", Value: "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{Path: "/secret/dev/web/db", Value: "v"}, s)
+}
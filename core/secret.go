@@ -1,6 +1,76 @@
 package core
 
+import (
+	"fmt"
+	"time"
+)
+
+// ValueField is the name of the field holding the value of a secret
+const ValueField = "value"
+
+// Metadata describes the stored version of a secret, as far as a backend knows it
+type Metadata struct {
+	Version  int
+	Modified time.Time
+}
+
+// Secret is a value stored at a path. Backends storing several named fields
+// per secret keep the "value" field in Value and any other fields in Fields.
+// An empty "value" field next to other fields is kept in Fields, so that
+// Data returns it.
 type Secret struct {
-	Path  string
-	Value string
+	Path     string
+	Value    string
+	Fields   map[string]string
+	Metadata Metadata
+	// Source is the path the secret was read from when a walk mapped it
+	// to another path, empty otherwise
+	Source string
+}
+
+// NewSecret returns a secret for the fields read from a backend
+func NewSecret(path string, data map[string]interface{}) Secret {
+	secret := Secret{Path: path}
+	for name, value := range data {
+		if name == ValueField {
+			secret.Value = fmt.Sprintf("%v", value)
+			if secret.Value != "" || len(data) == 1 {
+				continue
+			}
+		}
+		if secret.Fields == nil {
+			secret.Fields = make(map[string]string)
+		}
+		secret.Fields[name] = fmt.Sprintf("%v", value)
+	}
+	return secret
+}
+
+// SourcePath returns the path the secret was read from
+func (s Secret) SourcePath() string {
+	if s.Source != "" {
+		return s.Source
+	}
+	return s.Path
+}
+
+// Data returns all the fields of a secret, including the value field
+func (s Secret) Data() map[string]interface{} {
+	data := make(map[string]interface{}, len(s.Fields)+1)
+	for name, value := range s.Fields {
+		data[name] = value
+	}
+	if _, ok := s.Fields[ValueField]; !ok && (s.Value != "" || len(s.Fields) == 0) {
+		data[ValueField] = s.Value
+	}
+	return data
+}
+
+// Length returns the total length of the values of all the fields
+func (s Secret) Length() int {
+	n := len(s.Value)
+	for _, value := range s.Fields {
+		n += len(value)
+	}
+	return n
 }
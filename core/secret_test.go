@@ -0,0 +1,26 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSecret_Fields(t *testing.T) {
+	s := NewSecret("/secret/db", map[string]interface{}{"value": "v", "user": "admin", "port": 5432})
+	assert.Equal(t, "v", s.Value)
+	assert.Equal(t, map[string]string{"user": "admin", "port": "5432"}, s.Fields)
+	assert.Equal(t, map[string]interface{}{"value": "v", "user": "admin", "port": "5432"}, s.Data())
+}
+
+func TestSecret_Data(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"value": ""}, Secret{Path: "/secret/empty"}.Data())
+	s := Secret{Path: "/secret/db", Fields: map[string]string{"password": "hunter2"}}
+	assert.Equal(t, map[string]interface{}{"password": "hunter2"}, s.Data())
+	// an empty value read next to other fields is kept
+	data := map[string]interface{}{"value": "", "password": "hunter2"}
+	s = NewSecret("/secret/db", data)
+	assert.Equal(t, "", s.Value)
+	assert.Equal(t, data, s.Data())
+	assert.Equal(t, map[string]interface{}{"value": ""}, NewSecret("/secret/empty", map[string]interface{}{"value": ""}).Data())
+}
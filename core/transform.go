@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+)
+
+// Transformer changes a secret on its way from a source to a destination
+type Transformer interface {
+	Transform(secret Secret) (Secret, error)
+}
+
+// TransformFunc lets a function be used as a Transformer
+type TransformFunc func(secret Secret) (Secret, error)
+
+// Transform ...
+func (f TransformFunc) Transform(secret Secret) (Secret, error) {
+	return f(secret)
+}
+
+// Transforms applies a list of transformers in order
+type Transforms []Transformer
+
+// Transform ...
+func (t Transforms) Transform(secret Secret) (Secret, error) {
+	for _, transformer := range t {
+		var err error
+		if secret, err = transformer.Transform(secret); err != nil {
+			return secret, err
+		}
+	}
+	return secret, nil
+}
+
+// withData returns a copy of a secret holding data instead of its fields
+func (s Secret) withData(data map[string]interface{}) Secret {
+	secret := NewSecret(s.Path, data)
+	secret.Metadata = s.Metadata
+	return secret
+}
+
+// mapFields replaces the value of field, or of all the fields if field is empty
+func mapFields(s Secret, field string, f func(string) (string, error)) (Secret, error) {
+	data := s.Data()
+	for name, value := range data {
+		if field != "" && name != field {
+			continue
+		}
+		mapped, err := f(fmt.Sprintf("%v", value))
+		if err != nil {
+			return s, fmt.Errorf("field %s of %s: %v", name, s.Path, err)
+		}
+		data[name] = mapped
+	}
+	return s.withData(data), nil
+}
+
+// RenameField renames the field From to To, secrets without From are unchanged
+type RenameField struct {
+	From string
+	To   string
+}
+
+// Transform ...
+func (r RenameField) Transform(s Secret) (Secret, error) {
+	data := s.Data()
+	value, ok := data[r.From]
+	if !ok || r.From == r.To {
+		return s, nil
+	}
+	if _, exists := data[r.To]; exists {
+		return s, fmt.Errorf("cannot rename field %s of %s to the existing field %s", r.From, s.Path, r.To)
+	}
+	delete(data, r.From)
+	data[r.To] = value
+	return s.withData(data), nil
+}
+
+// DropField removes a field from the secrets
+type DropField struct {
+	Field string
+}
+
+// Transform ...
+func (d DropField) Transform(s Secret) (Secret, error) {
+	data := s.Data()
+	if _, ok := data[d.Field]; !ok {
+		return s, nil
+	}
+	delete(data, d.Field)
+	return s.withData(data), nil
+}
+
+// Base64Encode encodes the value of Field, or of all the fields if Field is empty
+type Base64Encode struct {
+	Field string
+}
+
+// Transform ...
+func (b Base64Encode) Transform(s Secret) (Secret, error) {
+	return mapFields(s, b.Field, func(value string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	})
+}
+
+// Base64Decode decodes the value of Field, or of all the fields if Field is empty
+type Base64Decode struct {
+	Field string
+}
+
+// Transform ...
+func (b Base64Decode) Transform(s Secret) (Secret, error) {
+	return mapFields(s, b.Field, func(value string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		return string(decoded), err
+	})
+}
+
+// ReplaceValue replaces the matches of Pattern in the value of Field, or of
+// all the fields if Field is empty. Replacement can refer to groups as $1.
+type ReplaceValue struct {
+	Field       string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Transform ...
+func (r ReplaceValue) Transform(s Secret) (Secret, error) {
+	return mapFields(s, r.Field, func(value string) (string, error) {
+		return r.Pattern.ReplaceAllString(value, r.Replacement), nil
+	})
+}
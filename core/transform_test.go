@@ -0,0 +1,37 @@
+package core
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransforms(t *testing.T) {
+	s := Secret{Path: "/secret/db", Value: "hunter2", Fields: map[string]string{"url": "db.dev.example.com", "tmp": "x"}, Metadata: Metadata{Version: 3}}
+	transforms := Transforms{
+		RenameField{From: "value", To: "password"},
+		DropField{Field: "tmp"},
+		ReplaceValue{Field: "url", Pattern: regexp.MustCompile(`\.dev\.(example\.com)$`), Replacement: ".prod.$1"},
+		Base64Encode{Field: "password"},
+	}
+	result, err := transforms.Transform(s)
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{Path: "/secret/db", Fields: map[string]string{"password": "aHVudGVyMg==", "url": "db.prod.example.com"}, Metadata: Metadata{Version: 3}}, result)
+	assert.Equal(t, "x", s.Fields["tmp"], "the source secret should not change")
+
+	decoded, err := Base64Decode{}.Transform(result)
+	assert.Error(t, err, "url is not base64")
+	decoded, err = Base64Decode{Field: "password"}.Transform(result)
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", decoded.Fields["password"])
+}
+
+func TestRenameField_Existing(t *testing.T) {
+	s := Secret{Path: "/secret/db", Value: "a", Fields: map[string]string{"password": "b"}}
+	_, err := RenameField{From: "value", To: "password"}.Transform(s)
+	assert.Error(t, err)
+	unchanged, err := RenameField{From: "missing", To: "other"}.Transform(s)
+	assert.NoError(t, err)
+	assert.Equal(t, s, unchanged)
+}
@@ -1,5 +1,9 @@
 package core
 
+import (
+	"fmt"
+)
+
 // Visitor is passed a Secret
 type Visitor interface {
 	Visit(secret Secret)
@@ -9,3 +13,63 @@ type Visitor interface {
 type Walker interface {
 	Walk(visitor Visitor)
 }
+
+// SecretList is a visitor collecting the secrets of a walk
+type SecretList []Secret
+
+// Visit ...
+func (l *SecretList) Visit(s Secret) {
+	*l = append(*l, s)
+}
+
+// VersionSkipper is implemented by visitors that already hold some secrets.
+// Walkers that can look up the current version of a secret cheaply skip
+// reading and visiting it when Unchanged reports the version is known.
+type VersionSkipper interface {
+	Unchanged(path string, version int) bool
+}
+
+// ErrorVisitor is implemented by visitors that need to know a walk was
+// incomplete, e.g. before deleting the secrets that were not visited
+type ErrorVisitor interface {
+	VisitError(path string, err error)
+}
+
+// ReportError tells a visitor implementing ErrorVisitor that path could not be walked
+func ReportError(visitor Visitor, path string, err error) {
+	if v, ok := visitor.(ErrorVisitor); ok && err != nil {
+		v.VisitError(path, err)
+	}
+}
+
+// Walk walks w with visitor, returning an error if the walk was incomplete
+func Walk(w Walker, visitor Visitor) error {
+	c := &errorCollector{visitor: visitor}
+	w.Walk(c)
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("incomplete walk, %d errors, the first: %s", len(c.errs), c.errs[0])
+}
+
+// errorCollector passes everything on to a visitor, collecting the walk errors
+type errorCollector struct {
+	visitor Visitor
+	errs    []string
+}
+
+func (c *errorCollector) Visit(secret Secret) {
+	c.visitor.Visit(secret)
+}
+
+func (c *errorCollector) Unchanged(path string, version int) bool {
+	if skipper, ok := c.visitor.(VersionSkipper); ok {
+		return skipper.Unchanged(path, version)
+	}
+	return false
+}
+
+func (c *errorCollector) VisitError(path string, err error) {
+	c.errs = append(c.errs, fmt.Sprintf("%s: %v", path, err))
+	ReportError(c.visitor, path, err)
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type walkerFunc func(visitor Visitor)
+
+func (f walkerFunc) Walk(visitor Visitor) { f(visitor) }
+
+type recorder struct {
+	paths []string
+}
+
+func (r *recorder) Visit(s Secret) { r.paths = append(r.paths, s.Path) }
+
+func TestWalk_Errors(t *testing.T) {
+	complete := walkerFunc(func(visitor Visitor) {
+		visitor.Visit(Secret{Path: "/a"})
+	})
+	r := &recorder{}
+	assert.NoError(t, Walk(complete, r))
+	assert.Equal(t, []string{"/a"}, r.paths)
+
+	incomplete := walkerFunc(func(visitor Visitor) {
+		visitor.Visit(Secret{Path: "/b"})
+		ReportError(visitor, "/sub/", errors.New("permission denied"))
+		ReportError(visitor, "/other/", errors.New("timeout"))
+	})
+	err := Walk(incomplete, r)
+	assert.EqualError(t, err, "incomplete walk, 2 errors, the first: /sub/: permission denied")
+	assert.Equal(t, []string{"/a", "/b"}, r.paths)
+}
@@ -81,7 +81,7 @@ func TestIntegration_SyncretsSyncToJSON(t *testing.T) {
 	}
 	json := string(bytes)
 	jsonTrimmed := strings.TrimSpace(json)
-	expected := `{"secret":{"foo":{".":"bar","bar":"foobar"},"gilbert":"sullivan","it":{"was":{"the":{"best":{"of":{"times":"it was the worst of times"}}}}}}}`
+	expected := `{"syncrets":{"version":1,"layout":"nested"},"secrets":{"secret":{"foo":{".":"bar","bar":"foobar"},"gilbert":"sullivan","it":{"was":{"the":{"best":{"of":{"times":"it was the worst of times"}}}}}}}}`
 	if jsonTrimmed != expected {
 		t.Fatalf("JSON output not as expected: %v", json)
 	}